@@ -0,0 +1,323 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/whawty/groups/store"
+)
+
+// api serves the REST JSON API for a store. All paths are relative to the
+// prefix /api:
+//
+//	GET    /users                         list users, filtered by ?prefix=
+//	GET    /users/<user>                  meta data of user
+//	POST   /users/<user>                  add user
+//	PUT    /users/<user>                  replace meta data of user
+//	DELETE /users/<user>                  remove user
+//	GET    /users/<user>/groups           groups of user, ?effective=true includes nested groups
+//	GET    /groups                        list groups, filtered by ?prefix=
+//	GET    /groups/<group>                meta data of group
+//	POST   /groups/<group>                add group
+//	PUT    /groups/<group>                replace meta data of group
+//	DELETE /groups/<group>                remove group, ?force=true also removes non-empty groups
+//	GET    /groups/<group>/members        direct user and group members
+//	GET    /groups/<group>/members/effective
+//	                                      all users which are members directly or through nested groups
+//	GET    /groups/<group>/members/effective/<user>
+//	                                      whether user is a member directly or through nested groups
+//	PUT    /groups/<group>/users/<user>   add user to group
+//	DELETE /groups/<group>/users/<user>   remove user from group
+//	PUT    /groups/<group>/groups/<member>
+//	                                      add member to group
+//	DELETE /groups/<group>/groups/<member>
+//	                                      remove member from group
+type api struct {
+	store *store.Dir
+}
+
+// apiError is an error together with the HTTP status code it should be reported
+// with.
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func newAPIError(status int, format string, a ...interface{}) error {
+	return &apiError{status, fmt.Errorf(format, a...)}
+}
+
+// errorStatus maps errors returned by the store to HTTP status codes.
+func errorStatus(err error) int {
+	switch err := err.(type) {
+	case *apiError:
+		return err.status
+	case *store.RollbackError:
+		return http.StatusInternalServerError
+	case *store.InvalidNameError, *store.ReservedKeyError, *store.SelfMemberError:
+		return http.StatusBadRequest
+	case *store.LoopError, *store.NotEmptyError, *store.GIDError:
+		return http.StatusConflict
+	}
+	switch {
+	case store.IsNotExist(err):
+		return http.StatusNotFound
+	case store.IsExist(err):
+		return http.StatusConflict
+	case err == store.ErrLockTimeout:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, errorStatus(err), map[string]string{"error": err.Error()})
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return newAPIError(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+	return nil
+}
+
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api"), "/")
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+
+	status, result, err := a.route(r, parts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if result == nil {
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, result)
+}
+
+// route dispatches the request to the handler for the path parts. It returns
+// the status code and the result which gets encoded as JSON or nil if the
+// response has no body.
+func (a *api) route(r *http.Request, parts []string) (int, interface{}, error) {
+	if len(parts) == 0 || (parts[0] != "users" && parts[0] != "groups") {
+		return 0, nil, newAPIError(http.StatusNotFound, "not found")
+	}
+	if parts[0] == "users" {
+		switch len(parts) {
+		case 1:
+			return a.handle(r, handlers{"GET": a.listUsers})
+		case 2:
+			return a.handle(r, handlers{"GET": a.getUser, "POST": a.addUser, "PUT": a.setUser, "DELETE": a.removeUser}, parts[1])
+		case 3:
+			if parts[2] == "groups" {
+				return a.handle(r, handlers{"GET": a.groupsOf}, parts[1])
+			}
+		}
+		return 0, nil, newAPIError(http.StatusNotFound, "not found")
+	}
+
+	switch len(parts) {
+	case 1:
+		return a.handle(r, handlers{"GET": a.listGroups})
+	case 2:
+		return a.handle(r, handlers{"GET": a.getGroup, "POST": a.addGroup, "PUT": a.setGroup, "DELETE": a.removeGroup}, parts[1])
+	case 3:
+		if parts[2] == "members" {
+			return a.handle(r, handlers{"GET": a.listMembers}, parts[1])
+		}
+	case 4:
+		switch parts[2] {
+		case "members":
+			if parts[3] == "effective" {
+				return a.handle(r, handlers{"GET": a.effectiveMembers}, parts[1])
+			}
+		case "users":
+			return a.handle(r, handlers{"PUT": a.addUserMember, "DELETE": a.removeUserMember}, parts[1], parts[3])
+		case "groups":
+			return a.handle(r, handlers{"PUT": a.addGroupMember, "DELETE": a.removeGroupMember}, parts[1], parts[3])
+		}
+	case 5:
+		if parts[2] == "members" && parts[3] == "effective" {
+			return a.handle(r, handlers{"GET": a.isMember}, parts[1], parts[4])
+		}
+	}
+	return 0, nil, newAPIError(http.StatusNotFound, "not found")
+}
+
+// handler handles a request for the names contained in the path.
+type handler func(r *http.Request, names []string) (int, interface{}, error)
+
+// handlers maps HTTP methods to handlers.
+type handlers map[string]handler
+
+func (a *api) handle(r *http.Request, hs handlers, names ...string) (int, interface{}, error) {
+	h, exists := hs[r.Method]
+	if !exists {
+		return 0, nil, newAPIError(http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+	}
+	for _, name := range names {
+		if !store.IsValidName(name) {
+			return 0, nil, &store.InvalidNameError{Name: name}
+		}
+	}
+	return h(r, names)
+}
+
+func (a *api) listUsers(r *http.Request, _ []string) (int, interface{}, error) {
+	users, err := a.store.ListUsers(r.URL.Query().Get("prefix"))
+	return http.StatusOK, users, err
+}
+
+func (a *api) getUser(r *http.Request, names []string) (int, interface{}, error) {
+	meta, err := a.store.GetUserMeta(names[0])
+	return http.StatusOK, meta, err
+}
+
+func (a *api) addUser(r *http.Request, names []string) (int, interface{}, error) {
+	if err := a.store.AddUser(names[0]); err != nil {
+		return 0, nil, err
+	}
+	meta, err := a.store.GetUserMeta(names[0])
+	return http.StatusCreated, meta, err
+}
+
+func (a *api) setUser(r *http.Request, names []string) (int, interface{}, error) {
+	meta := &store.UserMeta{}
+	if err := readJSON(r, meta); err != nil {
+		return 0, nil, err
+	}
+	if err := a.store.SetUserMeta(names[0], meta); err != nil {
+		return 0, nil, err
+	}
+	meta, err := a.store.GetUserMeta(names[0])
+	return http.StatusOK, meta, err
+}
+
+func (a *api) removeUser(r *http.Request, names []string) (int, interface{}, error) {
+	groups, err := a.store.RemoveUser(names[0])
+	return http.StatusOK, map[string][]string{"groups": groups}, err
+}
+
+func (a *api) groupsOf(r *http.Request, names []string) (int, interface{}, error) {
+	var groups []string
+	var err error
+	if r.URL.Query().Get("effective") == "true" {
+		groups, err = a.store.EffectiveGroupsOf(names[0])
+	} else {
+		groups, err = a.store.GroupsOf(names[0])
+	}
+	return http.StatusOK, groups, err
+}
+
+func (a *api) listGroups(r *http.Request, _ []string) (int, interface{}, error) {
+	groups, err := a.store.ListGroups(r.URL.Query().Get("prefix"))
+	return http.StatusOK, groups, err
+}
+
+func (a *api) getGroup(r *http.Request, names []string) (int, interface{}, error) {
+	meta, err := a.store.GetGroupMeta(names[0])
+	return http.StatusOK, meta, err
+}
+
+func (a *api) addGroup(r *http.Request, names []string) (int, interface{}, error) {
+	if err := a.store.AddGroup(names[0]); err != nil {
+		return 0, nil, err
+	}
+	meta, err := a.store.GetGroupMeta(names[0])
+	return http.StatusCreated, meta, err
+}
+
+func (a *api) setGroup(r *http.Request, names []string) (int, interface{}, error) {
+	meta := &store.GroupMeta{}
+	if err := readJSON(r, meta); err != nil {
+		return 0, nil, err
+	}
+	if err := a.store.SetGroupMeta(names[0], meta); err != nil {
+		return 0, nil, err
+	}
+	meta, err := a.store.GetGroupMeta(names[0])
+	return http.StatusOK, meta, err
+}
+
+func (a *api) removeGroup(r *http.Request, names []string) (int, interface{}, error) {
+	groups, err := a.store.RemoveGroup(names[0], r.URL.Query().Get("force") == "true")
+	return http.StatusOK, map[string][]string{"groups": groups}, err
+}
+
+func (a *api) listMembers(r *http.Request, names []string) (int, interface{}, error) {
+	users, groups, err := a.store.ListMembers(names[0])
+	return http.StatusOK, map[string][]string{"users": users, "groups": groups}, err
+}
+
+func (a *api) effectiveMembers(r *http.Request, names []string) (int, interface{}, error) {
+	users, err := a.store.EffectiveMembers(names[0])
+	return http.StatusOK, users, err
+}
+
+func (a *api) isMember(r *http.Request, names []string) (int, interface{}, error) {
+	isMember, err := a.store.IsMember(names[0], names[1])
+	return http.StatusOK, map[string]bool{"member": isMember}, err
+}
+
+func (a *api) addUserMember(r *http.Request, names []string) (int, interface{}, error) {
+	return http.StatusNoContent, nil, a.store.AddUserMember(names[0], names[1])
+}
+
+func (a *api) removeUserMember(r *http.Request, names []string) (int, interface{}, error) {
+	return http.StatusNoContent, nil, a.store.RemoveUserMember(names[0], names[1])
+}
+
+func (a *api) addGroupMember(r *http.Request, names []string) (int, interface{}, error) {
+	return http.StatusNoContent, nil, a.store.AddGroupMember(names[0], names[1])
+}
+
+func (a *api) removeGroupMember(r *http.Request, names []string) (int, interface{}, error) {
+	return http.StatusNoContent, nil, a.store.RemoveGroupMember(names[0], names[1])
+}
@@ -0,0 +1,236 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/whawty/groups/store"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, func()) {
+	basedir, err := ioutil.TempDir("", "whawty-groups-api")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	s := store.NewDirWithOptions(basedir, store.Options{Actor: "api-test"})
+	if err := s.Init(); err != nil {
+		os.RemoveAll(basedir)
+		t.Fatal("unexpected error:", err)
+	}
+	srv := httptest.NewServer(&api{s})
+	return srv, func() {
+		srv.Close()
+		os.RemoveAll(basedir)
+	}
+}
+
+// request sends a request to srv and checks the status code of the response.
+// If result is not nil the response body is decoded into it.
+func request(t *testing.T, srv *httptest.Server, method, path string, body interface{}, status int, result interface{}) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s returned status %d, expected %d: %s", method, path, resp.StatusCode, status, content)
+	}
+	if result != nil {
+		if err := json.Unmarshal(content, result); err != nil {
+			t.Fatalf("%s %s returned invalid JSON: %v: %s", method, path, err, content)
+		}
+	}
+}
+
+func TestAPIUsersAndGroups(t *testing.T) {
+	srv, cleanup := newTestServer(t)
+	defer cleanup()
+
+	var names []string
+	request(t, srv, "GET", "/api/users", nil, http.StatusOK, &names)
+	if len(names) != 0 {
+		t.Fatalf("expected no users, got %v", names)
+	}
+
+	var user store.UserMeta
+	request(t, srv, "POST", "/api/users/hugo", nil, http.StatusCreated, &user)
+	request(t, srv, "POST", "/api/users/hugo", nil, http.StatusConflict, nil)
+	request(t, srv, "POST", "/api/users/-hugo", nil, http.StatusBadRequest, nil)
+	request(t, srv, "POST", "/api/users/heinz", nil, http.StatusCreated, nil)
+	request(t, srv, "GET", "/api/users?prefix=hu", nil, http.StatusOK, &names)
+	if expected := []string{"hugo"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected users %v, got %v", expected, names)
+	}
+
+	request(t, srv, "PUT", "/api/users/hugo", &store.UserMeta{FirstName: "Hugo", Mail: "hugo@example.com"}, http.StatusOK, &user)
+	if user.FirstName != "Hugo" || user.Mail != "hugo@example.com" || user.Changed.IsZero() {
+		t.Fatalf("wrong meta data after update: %+v", user)
+	}
+	request(t, srv, "GET", "/api/users/hugo", nil, http.StatusOK, &user)
+	if user.FirstName != "Hugo" {
+		t.Fatalf("wrong meta data: %+v", user)
+	}
+	request(t, srv, "GET", "/api/users/fredl", nil, http.StatusNotFound, nil)
+	request(t, srv, "PUT", "/api/users/fredl", &store.UserMeta{}, http.StatusNotFound, nil)
+
+	var group store.GroupMeta
+	request(t, srv, "POST", "/api/groups/admins", nil, http.StatusCreated, &group)
+	request(t, srv, "POST", "/api/groups/hugo", nil, http.StatusConflict, nil)
+	request(t, srv, "PUT", "/api/groups/admins", &store.GroupMeta{Description: "root access"}, http.StatusOK, &group)
+	if group.Description != "root access" {
+		t.Fatalf("wrong meta data after update: %+v", group)
+	}
+	request(t, srv, "GET", "/api/groups", nil, http.StatusOK, &names)
+	if expected := []string{"admins"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected groups %v, got %v", expected, names)
+	}
+
+	var removed map[string][]string
+	request(t, srv, "DELETE", "/api/users/heinz", nil, http.StatusOK, &removed)
+	request(t, srv, "DELETE", "/api/users/heinz", nil, http.StatusNotFound, nil)
+	request(t, srv, "DELETE", "/api/groups/admins", nil, http.StatusOK, &removed)
+	request(t, srv, "GET", "/api/groups/admins", nil, http.StatusNotFound, nil)
+}
+
+func TestAPIMembers(t *testing.T) {
+	srv, cleanup := newTestServer(t)
+	defer cleanup()
+
+	for _, path := range []string{"/api/users/hugo", "/api/users/fredl", "/api/groups/admins", "/api/groups/devs"} {
+		request(t, srv, "POST", path, nil, http.StatusCreated, nil)
+	}
+	request(t, srv, "PUT", "/api/groups/admins/users/hugo", nil, http.StatusNoContent, nil)
+	request(t, srv, "PUT", "/api/groups/admins/groups/devs", nil, http.StatusNoContent, nil)
+	request(t, srv, "PUT", "/api/groups/devs/users/fredl", nil, http.StatusNoContent, nil)
+	request(t, srv, "PUT", "/api/groups/devs/users/heinz", nil, http.StatusNotFound, nil)
+	request(t, srv, "PUT", "/api/groups/ops/users/hugo", nil, http.StatusNotFound, nil)
+	request(t, srv, "PUT", "/api/groups/devs/groups/admins", nil, http.StatusConflict, nil)
+	request(t, srv, "PUT", "/api/groups/devs/groups/devs", nil, http.StatusBadRequest, nil)
+
+	var members map[string][]string
+	request(t, srv, "GET", "/api/groups/admins/members", nil, http.StatusOK, &members)
+	if expected := map[string][]string{"users": {"hugo"}, "groups": {"devs"}}; !reflect.DeepEqual(members, expected) {
+		t.Fatalf("expected members %v, got %v", expected, members)
+	}
+	var names []string
+	request(t, srv, "GET", "/api/groups/admins/members/effective", nil, http.StatusOK, &names)
+	if expected := []string{"fredl", "hugo"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected effective members %v, got %v", expected, names)
+	}
+	var isMember map[string]bool
+	request(t, srv, "GET", "/api/groups/admins/members/effective/fredl", nil, http.StatusOK, &isMember)
+	if !isMember["member"] {
+		t.Fatal("'fredl' should be an effective member of 'admins'")
+	}
+	request(t, srv, "GET", "/api/users/fredl/groups", nil, http.StatusOK, &names)
+	if expected := []string{"devs"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected groups %v, got %v", expected, names)
+	}
+	request(t, srv, "GET", "/api/users/fredl/groups?effective=true", nil, http.StatusOK, &names)
+	if expected := []string{"admins", "devs"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected effective groups %v, got %v", expected, names)
+	}
+
+	request(t, srv, "DELETE", "/api/groups/devs", nil, http.StatusConflict, nil)
+	request(t, srv, "DELETE", "/api/groups/admins/users/hugo", nil, http.StatusNoContent, nil)
+	request(t, srv, "DELETE", "/api/groups/admins/groups/devs", nil, http.StatusNoContent, nil)
+	request(t, srv, "GET", "/api/groups/admins/members/effective", nil, http.StatusOK, &names)
+	if len(names) != 0 {
+		t.Fatalf("expected no effective members, got %v", names)
+	}
+	var removed map[string][]string
+	request(t, srv, "DELETE", "/api/groups/devs?force=true", nil, http.StatusOK, &removed)
+}
+
+func TestAPIErrors(t *testing.T) {
+	srv, cleanup := newTestServer(t)
+	defer cleanup()
+
+	request(t, srv, "GET", "/api/", nil, http.StatusNotFound, nil)
+	request(t, srv, "GET", "/api/unknown", nil, http.StatusNotFound, nil)
+	request(t, srv, "GET", "/api/users/hugo/unknown", nil, http.StatusNotFound, nil)
+	request(t, srv, "PATCH", "/api/users/hugo", nil, http.StatusMethodNotAllowed, nil)
+	request(t, srv, "POST", "/api/users", nil, http.StatusMethodNotAllowed, nil)
+	request(t, srv, "GET", "/api/groups/_meta.yaml", nil, http.StatusBadRequest, nil)
+	request(t, srv, "POST", "/api/users/hugo", nil, http.StatusCreated, nil)
+	request(t, srv, "PUT", "/api/users/hugo", "not an object", http.StatusBadRequest, nil)
+	request(t, srv, "PUT", "/api/users/hugo", map[string]interface{}{"extra": map[string]string{"mail": "x"}}, http.StatusBadRequest, nil)
+	request(t, srv, "POST", "/api/groups/admins", nil, http.StatusCreated, nil)
+	request(t, srv, "PUT", "/api/groups/admins", map[string]interface{}{"extra": map[string]string{"gid": "x"}}, http.StatusBadRequest, nil)
+	request(t, srv, "PUT", "/api/groups/admins", map[string]interface{}{"gid": 5}, http.StatusOK, nil)
+	request(t, srv, "POST", "/api/groups/devs", nil, http.StatusCreated, nil)
+	request(t, srv, "PUT", "/api/groups/devs", map[string]interface{}{"gid": 5}, http.StatusConflict, nil)
+
+	var body map[string]string
+	request(t, srv, "GET", "/api/users/fredl", nil, http.StatusNotFound, &body)
+	if body["error"] == "" {
+		t.Fatal("error responses should contain an error message")
+	}
+
+	for _, r := range []struct {
+		method, path, kind string
+	}{
+		{"GET", "/api/users/nobody", "user"},
+		{"PUT", "/api/users/nobody", "user"},
+		{"GET", "/api/groups/nobody", "group"},
+		{"PUT", "/api/groups/nobody", "group"},
+	} {
+		body = nil
+		request(t, srv, r.method, r.path, map[string]interface{}{}, http.StatusNotFound, &body)
+		if expected := (&store.NotExistError{Kind: r.kind, Name: "nobody"}).Error(); body["error"] != expected {
+			t.Fatalf("%s %s returned error '%s', expected '%s'", r.method, r.path, body["error"], expected)
+		}
+	}
+}
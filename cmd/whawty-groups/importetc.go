@@ -0,0 +1,79 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/whawty/groups/store"
+)
+
+func runImportEtc(s *store.Dir, args []string) error {
+	flags := flag.NewFlagSet("import-etc", flag.ExitOnError)
+	passwdFile := flags.String("passwd", "", "passwd file to import users from")
+	groupFile := flags.String("group", "", "group file to import groups from")
+	flags.Parse(args)
+
+	if *passwdFile == "" && *groupFile == "" {
+		return fmt.Errorf("at least one of -passwd and -group is required")
+	}
+
+	var passwd, group io.Reader
+	if *passwdFile != "" {
+		file, err := os.Open(*passwdFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		passwd = file
+	}
+	if *groupFile != "" {
+		file, err := os.Open(*groupFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		group = file
+	}
+
+	result, err := s.ImportEtc(passwd, group)
+	if err != nil {
+		return err
+	}
+	for _, r := range result.Rejected {
+		fmt.Fprintf(os.Stderr, "rejected %s\n", r)
+	}
+	fmt.Printf("imported %d users and %d groups, rejected %d entries\n", len(result.Users), len(result.Groups), len(result.Rejected))
+	return nil
+}
@@ -0,0 +1,75 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+
+	"github.com/whawty/groups/store"
+)
+
+func ldifFlags(name string) (*flag.FlagSet, *store.LDIFOptions) {
+	opts := &store.LDIFOptions{}
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(&opts.BaseDN, "base-dn", "", "base DN of all entries, e.g. dc=example,dc=com")
+	flags.StringVar(&opts.UsersRDN, "users-rdn", store.DefaultLDIFUsersRDN, "RDN of the entry containing all users")
+	flags.StringVar(&opts.GroupsRDN, "groups-rdn", store.DefaultLDIFGroupsRDN, "RDN of the entry containing all groups")
+	return flags, opts
+}
+
+func runExportLDIF(s *store.Dir, args []string) error {
+	flags, opts := ldifFlags("export-ldif")
+	output := flags.String("output", "", "file to write to (default: stdout)")
+	flags.Parse(args)
+
+	return writeOutput(*output, func(w io.Writer) error {
+		return s.WriteLDIF(w, *opts)
+	})
+}
+
+func runImportLDIF(s *store.Dir, args []string) error {
+	flags, opts := ldifFlags("import-ldif")
+	input := flags.String("input", "", "file to read from (default: stdin)")
+	flags.Parse(args)
+
+	if *input == "" {
+		return s.ReadLDIF(os.Stdin, *opts)
+	}
+
+	file, err := os.Open(*input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.ReadLDIF(file, *opts)
+}
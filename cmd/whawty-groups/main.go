@@ -0,0 +1,142 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/whawty/groups/store"
+)
+
+// command is a sub-command of whawty-groups. run gets called with the opened
+// store and the arguments following the name of the command.
+type command struct {
+	usage string
+	run   func(s *store.Dir, args []string) error
+}
+
+var commands = map[string]command{
+	"etc-group":   {"assign missing GIDs and write all groups in /etc/group format", runEtcGroup},
+	"import-etc":  {"import users and groups from passwd and group files", runImportEtc},
+	"export-ldif": {"write all users and groups as LDIF", runExportLDIF},
+	"import-ldif": {"add users and groups from an LDIF file", runImportLDIF},
+	"serve":       {"serve the HTTP JSON API", runServe},
+}
+
+// writeOutput calls write to create the file filename. The output is written to
+// a temporary file in the same directory which replaces filename only after
+// write succeeded. The permissions of an existing file are kept. If filename is
+// empty the output is written to stdout.
+func writeOutput(filename string, write func(w io.Writer) error) (err error) {
+	if filename == "" {
+		return write(os.Stdout)
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	if err = write(file); err != nil {
+		return
+	}
+	if err = file.Chmod(mode); err != nil {
+		return
+	}
+	if err = file.Sync(); err != nil {
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [options] <command> [arguments]\n\noptions:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the arguments of a command.\n", os.Args[0])
+}
+
+func main() {
+	var opts store.Options
+	basedir := flag.String("store", "/var/lib/whawty/groups", "base directory of the store")
+	flag.BoolVar(&opts.ImplicitUserGroups, "implicit-user-groups", false, "add an implicit group for every user")
+	flag.StringVar(&opts.Actor, "actor", "", "name recorded in the audit log (default: current user)")
+	flag.BoolVar(&opts.Git, "git", false, "commit every change to a git repository inside the store")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, exists := commands[flag.Arg(0)]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	s := store.NewDirWithOptions(*basedir, opts)
+	if err := cmd.run(s, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,88 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "whawty-groups-output")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "group")
+	if err := ioutil.WriteFile(filename, []byte("old\n"), 0640); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if err := writeOutput(filename, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("failed")
+	}); err == nil {
+		t.Fatal("writeOutput should return the error of write")
+	}
+	if data, err := ioutil.ReadFile(filename); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if string(data) != "old\n" {
+		t.Fatalf("failed write must not change the file, got '%s'", data)
+	}
+
+	if err := writeOutput(filename, func(w io.Writer) error {
+		_, err := io.WriteString(w, "new\n")
+		return err
+	}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if data, err := ioutil.ReadFile(filename); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if string(data) != "new\n" {
+		t.Fatalf("file should contain the new output, got '%s'", data)
+	}
+	if fi, err := os.Stat(filename); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if fi.Mode().Perm() != 0640 {
+		t.Fatalf("permissions of the file should be kept, got %v", fi.Mode().Perm())
+	}
+
+	if names, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if len(names) != 1 {
+		t.Fatalf("temporary files should be removed, found %d files", len(names))
+	}
+}
@@ -0,0 +1,256 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+const auditLogFile string = "audit.log"
+
+// These are the operations recorded in the audit log.
+const (
+	AuditInit              = "init"
+	AuditRepair            = "repair"
+	AuditImport            = "import"
+	AuditAssignGIDs        = "assign-gids"
+	AuditAddUser           = "add-user"
+	AuditRemoveUser        = "remove-user"
+	AuditRenameUser        = "rename-user"
+	AuditSetUserMeta       = "set-user-meta"
+	AuditAddGroup          = "add-group"
+	AuditRemoveGroup       = "remove-group"
+	AuditRenameGroup       = "rename-group"
+	AuditSetGroupMeta      = "set-group-meta"
+	AuditAddUserMember     = "add-user-member"
+	AuditRemoveUserMember  = "remove-user-member"
+	AuditAddGroupMember    = "add-group-member"
+	AuditRemoveGroupMember = "remove-group-member"
+)
+
+// AuditRecord is a single entry of the audit log. Target is the user or group
+// which was changed. For membership changes Target is the group and Member is
+// the user or group which was added or removed. The contents of Before and
+// After depend on the operation:
+//
+//	remove-user, remove-group:  Before lists the groups which contained Target
+//	rename-user, rename-group:  Before is the old and After the new name
+//	set-user-meta, set-group-meta:  Before and After contain the meta data
+//	*-member:  Before and After tell whether Member was linked to from Target
+//	repair:  After lists the fixes which were applied
+//	import:  After contains the number of imported users and groups
+//	assign-gids:  After maps the group names to the newly assigned GIDs
+type AuditRecord struct {
+	Time      time.Time   `json:"time"`
+	Actor     string      `json:"actor"`
+	Operation string      `json:"operation"`
+	Target    string      `json:"target,omitempty"`
+	Member    string      `json:"member,omitempty"`
+	Before    interface{} `json:"before,omitempty"`
+	After     interface{} `json:"after,omitempty"`
+}
+
+// involves checks whether the record refers to the user or group principal.
+func (r *AuditRecord) involves(principal string) bool {
+	if r.Target == principal || r.Member == principal {
+		return true
+	}
+	switch r.Operation {
+	case AuditRenameUser, AuditRenameGroup:
+		return r.After == principal
+	}
+	return false
+}
+
+// jsonValue converts maps decoded by the YAML parser, which may have non-string
+// keys, so they can be encoded as JSON.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprintf("%v", key)] = jsonValue(value)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = jsonValue(value)
+		}
+		return l
+	}
+	return v
+}
+
+// jsonExtra converts the extra meta data keys so they can be encoded as JSON.
+func jsonExtra(extra map[string]interface{}) map[string]interface{} {
+	if extra == nil {
+		return nil
+	}
+	return jsonValue(extra).(map[string]interface{})
+}
+
+// String returns a short description of the record, e.g. "add-user-member
+// admins hugo".
+func (r *AuditRecord) String() string {
+	s := r.Operation
+	for _, name := range []string{r.Target, r.Member} {
+		if name != "" {
+			s += " " + name
+		}
+	}
+	switch r.Operation {
+	case AuditRenameUser, AuditRenameGroup:
+		s += fmt.Sprintf(" -> %v", r.After)
+	}
+	return s
+}
+
+// defaultActor returns the name of the user running the current process.
+func defaultActor() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+func (d *Dir) newAuditRecord(op, target, member string, before, after interface{}) *AuditRecord {
+	return &AuditRecord{time.Now(), d.opts.Actor, op, target, member, before, after}
+}
+
+// audit appends records to the audit log.
+func (d *Dir) audit(records ...*AuditRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Join(d.basedir, auditLogFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(buf.Bytes()); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// undoAuditLog returns a function which truncates the audit log to its current
+// size.
+func (d *Dir) undoAuditLog() (func() error, error) {
+	filename := filepath.Join(d.basedir, auditLogFile)
+	fi, err := os.Stat(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		return func() error {
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}, nil
+	}
+	size := fi.Size()
+	return func() error {
+		return os.Truncate(filename, size)
+	}, nil
+}
+
+// AuditLog returns all records of the audit log which involve the user or
+// group principal and were recorded between from and to (inclusive). If
+// principal is empty records for all principals are returned, if from or to
+// are zero the time range is not limited in that direction.
+func (d *Dir) AuditLog(principal string, from, to time.Time) ([]AuditRecord, error) {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	records := []AuditRecord{}
+	file, err := os.Open(filepath.Join(d.basedir, auditLogFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	for lineno := 1; ; lineno++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			var record AuditRecord
+			if jerr := json.Unmarshal(line, &record); jerr != nil {
+				return nil, fmt.Errorf("whawty.groups.store: can't parse line %d of audit log: %v", lineno, jerr)
+			}
+			if (principal == "" || record.involves(principal)) &&
+				(from.IsZero() || !record.Time.Before(from)) && (to.IsZero() || !record.Time.After(to)) {
+				records = append(records, record)
+			}
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+	}
+}
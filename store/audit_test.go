@@ -0,0 +1,169 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	store := NewDirWithOptions(testBaseDir, Options{Actor: "admin"})
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUser("hugo"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroup("admins"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	start := time.Now()
+	if err := store.AddUserMember("admins", "hugo"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, err := store.RenameUser("hugo", "fredl"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.SetUserMeta("fredl", &UserMeta{FirstName: "Fredl"}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	records, err := store.AuditLog("", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	ops := []string{AuditInit, AuditAddUser, AuditAddGroup, AuditAddUserMember, AuditRenameUser, AuditSetUserMeta}
+	if len(records) != len(ops) {
+		t.Fatalf("AuditLog returned %d records, expected %d", len(records), len(ops))
+	}
+	for i, r := range records {
+		if r.Operation != ops[i] {
+			t.Fatalf("record %d has operation '%s', expected '%s'", i, r.Operation, ops[i])
+		}
+		if r.Actor != "admin" {
+			t.Fatalf("record %d has actor '%s', expected 'admin'", i, r.Actor)
+		}
+	}
+	if r := records[3]; r.Target != "admins" || r.Member != "hugo" || r.Before != false || r.After != true {
+		t.Fatalf("unexpected membership record: %+v", r)
+	}
+
+	// records of renamed users are found by the old and the new name
+	if records, err = store.AuditLog("hugo", time.Time{}, time.Time{}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("AuditLog returned %d records for 'hugo', expected 3", len(records))
+	}
+	if records, err = store.AuditLog("fredl", time.Time{}, time.Time{}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("AuditLog returned %d records for 'fredl', expected 2", len(records))
+	}
+
+	if records, err = store.AuditLog("admins", start, time.Time{}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(records) != 1 || records[0].Operation != AuditAddUserMember {
+		t.Fatalf("AuditLog returned unexpected records for 'admins' since %v: %+v", start, records)
+	}
+	if records, err = store.AuditLog("", time.Time{}, start); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("AuditLog returned %d records until %v, expected 3", len(records), start)
+	}
+}
+
+func TestAuditLogFailedChange(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUser("hugo"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroup("hugo"); err == nil {
+		t.Fatal("adding a group with the name of a user should throw an error")
+	}
+	if err := store.UpdateUserMeta("hugo", func(meta *UserMeta) error {
+		meta.Extra = map[string]interface{}{"ssh": map[interface{}]interface{}{"keys": []interface{}{"ssh-ed25519 AAAA"}}}
+		return nil
+	}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	records, err := store.AuditLog("hugo", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(records) != 2 || records[0].Operation != AuditAddUser || records[1].Operation != AuditSetUserMeta {
+		t.Fatalf("AuditLog returned unexpected records: %+v", records)
+	}
+	if records[0].Actor == "" {
+		t.Fatal("records should have a default actor")
+	}
+}
+
+func TestAuditLogCheck(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, err := os.Stat(filepath.Join(testBaseDir, auditLogFile)); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.Check(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+}
@@ -0,0 +1,441 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FindingKind describes the type of a problem found by Check.
+type FindingKind int
+
+// These are the types of problems Check reports.
+const (
+	FindingUnknownEntry     FindingKind = iota // unknown file or directory
+	FindingMissingDir                          // users or groups directory is missing
+	FindingNotADir                             // entry should be a directory but isn't
+	FindingInvalidName                         // user or group name doesn't match the naming rules
+	FindingInvalidUserFile                     // user file is not a regular file or can't be parsed
+	FindingMissingMetaFile                     // group directory has no meta data file
+	FindingInvalidMetaFile                     // group meta data file is not a regular file or can't be parsed
+	FindingNotASymlink                         // entry inside a group directory is not a symlink
+	FindingDanglingLink                        // membership link points to a not existing user or group
+	FindingOutOfStoreLink                      // membership link points outside of the store
+	FindingAbsoluteLink                        // membership link uses an absolute path
+	FindingLinkNameMismatch                    // membership link name differs from the name of its target
+	FindingNameCollision                       // a user and a group share the same name
+	FindingLoop                                // nested group memberships form a loop
+	FindingStaleTempFile                       // left-over file in the temporary directory
+)
+
+// staleTempFileAge is the age after which files in the temporary directory are
+// considered left-overs of crashed or aborted updates.
+const staleTempFileAge = time.Hour
+
+var findingKindNames = map[FindingKind]string{
+	FindingUnknownEntry:     "unknown entry",
+	FindingMissingDir:       "missing directory",
+	FindingNotADir:          "not a directory",
+	FindingInvalidName:      "invalid name",
+	FindingInvalidUserFile:  "invalid user file",
+	FindingMissingMetaFile:  "missing meta file",
+	FindingInvalidMetaFile:  "invalid meta file",
+	FindingNotASymlink:      "not a symlink",
+	FindingDanglingLink:     "dangling link",
+	FindingOutOfStoreLink:   "out-of-store link",
+	FindingAbsoluteLink:     "absolute link",
+	FindingLinkNameMismatch: "link name mismatch",
+	FindingNameCollision:    "name collision",
+	FindingLoop:             "membership loop",
+	FindingStaleTempFile:    "stale temporary file",
+}
+
+func (k FindingKind) String() string {
+	if name, ok := findingKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown finding (%d)", int(k))
+}
+
+// Finding describes a single problem found by Check. Path is relative to the
+// base directory of the store.
+type Finding struct {
+	Kind    FindingKind
+	Path    string
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Path, f.Kind, f.Message)
+}
+
+// CheckError is returned by Check if the store contains any problems.
+type CheckError struct {
+	Findings []Finding
+}
+
+func (e *CheckError) Error() string {
+	msgs := make([]string, 0, len(e.Findings))
+	for _, f := range e.Findings {
+		msgs = append(msgs, f.String())
+	}
+	return fmt.Sprintf("whawty.groups.store: found %d problem(s): %s", len(e.Findings), strings.Join(msgs, "; "))
+}
+
+// checker collects the findings of a single run of Check.
+type checker struct {
+	store    *Dir
+	findings []Finding
+}
+
+func (c *checker) report(kind FindingKind, path, format string, a ...interface{}) {
+	c.findings = append(c.findings, Finding{kind, path, fmt.Sprintf(format, a...)})
+}
+
+// readDirnames returns the sorted names of all entries of the directory path
+// which is relative to the base directory.
+func (c *checker) readDirnames(path string) ([]string, error) {
+	dir, err := openDir(filepath.Join(c.store.basedir, path))
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(0)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c *checker) checkYAMLFile(kind FindingKind, path string) error {
+	filename := filepath.Join(c.store.basedir, path)
+	fi, err := os.Lstat(filename)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		c.report(kind, path, "not a regular file")
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	m := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		c.report(kind, path, "can't parse file: %v", err)
+	}
+	return nil
+}
+
+func (c *checker) checkUsers() ([]string, error) {
+	names, err := c.readDirnames(usersDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		path := filepath.Join(usersDir, name)
+		if !nameRe.MatchString(name) {
+			c.report(FindingInvalidName, path, "'%s' is not a valid user name", name)
+		}
+		if err := c.checkYAMLFile(FindingInvalidUserFile, path); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+func (c *checker) checkMember(group, name string) error {
+	path := filepath.Join(groupsDir, group, name)
+	fi, err := os.Lstat(filepath.Join(c.store.basedir, path))
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		c.report(FindingNotASymlink, path, "group directories must only contain symlinks")
+		return nil
+	}
+	if !nameRe.MatchString(name) {
+		c.report(FindingInvalidName, path, "'%s' is not a valid user or group name", name)
+	}
+
+	link, err := os.Readlink(filepath.Join(c.store.basedir, path))
+	if err != nil {
+		return err
+	}
+	target, err := NewGroupDir(c.store, group).readLink(name)
+	if err != nil {
+		return err
+	}
+	kind := "user"
+	switch parent := filepath.Dir(target); {
+	case sameFile(parent, filepath.Join(c.store.basedir, usersDir)):
+	case sameFile(parent, filepath.Join(c.store.basedir, groupsDir)):
+		kind = "group"
+	default:
+		c.report(FindingOutOfStoreLink, path, "link target '%s' is not a user or group of this store", link)
+		return nil
+	}
+	if filepath.IsAbs(link) {
+		c.report(FindingAbsoluteLink, path, "link target '%s' is absolute", link)
+	}
+	if filepath.Base(target) != name {
+		c.report(FindingLinkNameMismatch, path, "link points to %s '%s'", kind, filepath.Base(target))
+	}
+	if exists, err := fileExists(target); err != nil {
+		return err
+	} else if !exists {
+		c.report(FindingDanglingLink, path, "%s '%s' does not exist", kind, filepath.Base(target))
+	}
+	return nil
+}
+
+func (c *checker) checkGroups() ([]string, error) {
+	names, err := c.readDirnames(groupsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		path := filepath.Join(groupsDir, name)
+		if !nameRe.MatchString(name) {
+			c.report(FindingInvalidName, path, "'%s' is not a valid group name", name)
+		}
+		if err := isDir(filepath.Join(c.store.basedir, path)); err != nil {
+			c.report(FindingNotADir, path, "group must be a directory")
+			continue
+		}
+
+		members, err := c.readDirnames(path)
+		if err != nil {
+			return nil, err
+		}
+		if !contains(members, groupMetaFile) {
+			c.report(FindingMissingMetaFile, path, "group has no %s", groupMetaFile)
+		}
+		for _, member := range members {
+			if member == groupMetaFile {
+				err = c.checkYAMLFile(FindingInvalidMetaFile, filepath.Join(path, member))
+			} else {
+				err = c.checkMember(name, member)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return names, nil
+}
+
+func (c *checker) checkTmp() error {
+	dir, err := openDir(filepath.Join(c.store.basedir, tmpDir))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	fis, err := dir.Readdir(0)
+	if err != nil {
+		return err
+	}
+	sort.Sort(byName(fis))
+	for _, fi := range fis {
+		if age := time.Since(fi.ModTime()); age > staleTempFileAge {
+			c.report(FindingStaleTempFile, filepath.Join(tmpDir, fi.Name()), "file is %v old", age/time.Second*time.Second)
+		}
+	}
+	return nil
+}
+
+type byName []os.FileInfo
+
+func (fis byName) Len() int           { return len(fis) }
+func (fis byName) Less(i, j int) bool { return fis[i].Name() < fis[j].Name() }
+func (fis byName) Swap(i, j int)      { fis[i], fis[j] = fis[j], fis[i] }
+
+func (c *checker) checkLoops() error {
+	all, err := c.store.readAllMembers()
+	if err != nil {
+		return err
+	}
+	groups := make([]string, 0, len(all))
+	for group := range all {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	reported := make(map[string]bool)
+	visited := make(map[string]bool)
+	var path []string
+	var walk func(group string)
+	walk = func(group string) {
+		for i, g := range path {
+			if g == group {
+				loop := canonicalLoop(path[i:])
+				key := strings.Join(loop, "/")
+				if !reported[key] {
+					reported[key] = true
+					c.report(FindingLoop, filepath.Join(groupsDir, loop[0]), "%s", strings.Join(append(loop, loop[0]), " -> "))
+				}
+				return
+			}
+		}
+		if visited[group] {
+			return
+		}
+		visited[group] = true
+
+		path = append(path, group)
+		for _, member := range all[group].groups {
+			walk(member)
+		}
+		path = path[:len(path)-1]
+	}
+	for _, group := range groups {
+		walk(group)
+	}
+	return nil
+}
+
+// canonicalLoop rotates the groups of a loop so that it starts with the group
+// which sorts first.
+func canonicalLoop(loop []string) []string {
+	first := 0
+	for i, g := range loop {
+		if g < loop[first] {
+			first = i
+		}
+	}
+	return append(append([]string{}, loop[first:]...), loop[:first]...)
+}
+
+// Check tests if the directory is a valid whawty.group base directory. It
+// verifies the users and groups directories including all user files, group
+// meta data files and membership links. If any problems are found a *CheckError
+// containing all findings is returned. Other errors mean that the check could
+// not be completed.
+func (d *Dir) Check() error {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return d.check()
+}
+
+func (d *Dir) check() (err error) {
+	dir, err := openDir(d.basedir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(0)
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	c := &checker{store: d}
+	hasUsersDir := false
+	hasGroupsDir := false
+	for _, name := range names {
+		switch name {
+		case lockFile, auditLogFile, lastGIDFile:
+		case gitDir:
+			if !d.opts.Git {
+				c.report(FindingUnknownEntry, name, "unknown entry, git mode is disabled")
+			}
+		case tmpDir:
+			if err = isDir(filepath.Join(d.basedir, name)); err != nil {
+				c.report(FindingNotADir, name, "%v", err)
+				continue
+			}
+			if err = c.checkTmp(); err != nil {
+				return err
+			}
+		case usersDir:
+			if err = isDir(filepath.Join(d.basedir, name)); err != nil {
+				c.report(FindingNotADir, name, "%v", err)
+				continue
+			}
+			hasUsersDir = true
+		case groupsDir:
+			if err = isDir(filepath.Join(d.basedir, name)); err != nil {
+				c.report(FindingNotADir, name, "%v", err)
+				continue
+			}
+			hasGroupsDir = true
+		default:
+			c.report(FindingUnknownEntry, name, "found invalid file or directory")
+		}
+	}
+	if !hasGroupsDir && !contains(names, groupsDir) {
+		c.report(FindingMissingDir, groupsDir, "groups directory not found")
+	}
+	if !hasUsersDir && !contains(names, usersDir) {
+		c.report(FindingMissingDir, usersDir, "users directory not found")
+	}
+
+	if hasUsersDir && hasGroupsDir {
+		var users, groups []string
+		if users, err = c.checkUsers(); err != nil {
+			return err
+		}
+		if groups, err = c.checkGroups(); err != nil {
+			return err
+		}
+		for _, user := range users {
+			if contains(groups, user) {
+				c.report(FindingNameCollision, filepath.Join(groupsDir, user), "'%s' is used as user and group name", user)
+			}
+		}
+		if err = c.checkLoops(); err != nil {
+			return err
+		}
+	}
+
+	if len(c.findings) > 0 {
+		return &CheckError{c.findings}
+	}
+	return nil
+}
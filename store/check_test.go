@@ -0,0 +1,165 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func hasFinding(findings []Finding, kind FindingKind, path string) bool {
+	for _, f := range findings {
+		if f.Kind == kind && f.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckDirContents(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		if err := store.AddUser(user); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, group := range []string{"staff", "devs", "x", "y"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if err := store.AddUserMember("staff", "alice"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUserMember("devs", "bob"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroupMember("staff", "devs"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroupMember("x", "y"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if err := store.Check(); err != nil {
+		t.Fatalf("check should succeed for a consistent store: %v", err)
+	}
+
+	base := func(path ...string) string {
+		return filepath.Join(append([]string{testBaseDir}, path...)...)
+	}
+	abs, err := filepath.Abs(base(usersDir, "alice"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	files := []struct {
+		path    string
+		content string
+	}{
+		{base(usersDir, "_invalid"), "changed: 2016-01-01T00:00:00Z\n"},
+		{base(usersDir, "broken"), "- this: is\nnot: a map\n"},
+		{base(groupsDir, "file"), ""},
+		{base(groupsDir, "staff", "regular-file"), ""},
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	links := []struct {
+		target string
+		path   string
+	}{
+		{filepath.Join("..", "..", usersDir, "carol"), base(groupsDir, "staff", "carol")},
+		{"/etc/passwd", base(groupsDir, "staff", "passwd")},
+		{abs, base(groupsDir, "devs", "alice")},
+		{filepath.Join("..", "..", usersDir, "bob"), base(groupsDir, "devs", "robert")},
+		{filepath.Join("..", "x"), base(groupsDir, "y", "x")},
+	}
+	for _, l := range links {
+		if err := os.Symlink(l.target, l.path); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if err := os.Remove(base(groupsDir, "devs", groupMetaFile)); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := os.Mkdir(base(groupsDir, "bob"), 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	err = store.Check()
+	if err == nil {
+		t.Fatal("check should fail for an inconsistent store")
+	}
+	cerr, ok := err.(*CheckError)
+	if !ok {
+		t.Fatalf("check should return a CheckError, got: %v", err)
+	}
+
+	expected := []struct {
+		kind FindingKind
+		path string
+	}{
+		{FindingInvalidName, filepath.Join(usersDir, "_invalid")},
+		{FindingInvalidUserFile, filepath.Join(usersDir, "broken")},
+		{FindingNotADir, filepath.Join(groupsDir, "file")},
+		{FindingNotASymlink, filepath.Join(groupsDir, "staff", "regular-file")},
+		{FindingDanglingLink, filepath.Join(groupsDir, "staff", "carol")},
+		{FindingOutOfStoreLink, filepath.Join(groupsDir, "staff", "passwd")},
+		{FindingAbsoluteLink, filepath.Join(groupsDir, "devs", "alice")},
+		{FindingLinkNameMismatch, filepath.Join(groupsDir, "devs", "robert")},
+		{FindingMissingMetaFile, filepath.Join(groupsDir, "devs")},
+		{FindingMissingMetaFile, filepath.Join(groupsDir, "bob")},
+		{FindingNameCollision, filepath.Join(groupsDir, "bob")},
+		{FindingLoop, filepath.Join(groupsDir, "x")},
+	}
+	for _, e := range expected {
+		if !hasFinding(cerr.Findings, e.kind, e.path) {
+			t.Errorf("check didn't report '%s' for %s", e.kind, e.path)
+		}
+	}
+	if len(cerr.Findings) != len(expected) {
+		t.Errorf("check reported %d findings, expected %d: %v", len(cerr.Findings), len(expected), err)
+	}
+}
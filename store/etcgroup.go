@@ -0,0 +1,241 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultFirstGID is the lowest GID assigned by AssignGIDs if no other value is
+// given.
+const DefaultFirstGID = 10000
+
+// lastGIDFile contains the highest GID ever stored in the meta data of a group.
+// It makes sure the GIDs of removed groups are never assigned again.
+const lastGIDFile string = "last-gid"
+
+// lastGID returns the highest GID ever stored in the meta data of a group or
+// zero if no GID was stored yet.
+func (d *Dir) lastGID() (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(d.basedir, lastGIDFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	gid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("whawty.groups.store: can't parse %s: %v", lastGIDFile, err)
+	}
+	return gid, nil
+}
+
+// raiseLastGID records gid as the highest GID ever stored unless a higher one
+// has already been recorded.
+func (d *Dir) raiseLastGID(gid int) error {
+	last, err := d.lastGID()
+	if err != nil || gid <= last {
+		return err
+	}
+	return d.writeFile(filepath.Join(d.basedir, lastGIDFile), []byte(fmt.Sprintf("%d\n", gid)), 0644)
+}
+
+// AssignGIDs assigns a GID to all groups which don't have one yet. GIDs are
+// stored in the group meta data and never change once assigned. New GIDs are
+// allocated in order of the group names, starting after the highest GID ever
+// used by any group, including groups which have been removed since, but not
+// below firstGID. If firstGID is zero DefaultFirstGID is used. It returns the
+// newly assigned GIDs.
+func (d *Dir) AssignGIDs(firstGID int) (assigned map[string]int, err error) {
+	if firstGID <= 0 {
+		firstGID = DefaultFirstGID
+	}
+
+	tx := d.Begin()
+	tx.stage(func() (func() error, error) {
+		groups, err := d.listGroups()
+		if err != nil {
+			return nil, err
+		}
+		undo, err := d.undoFile(filepath.Join(d.basedir, lastGIDFile))
+		if err != nil {
+			return nil, err
+		}
+		undos := []func() error{undo}
+		for _, group := range groups {
+			undo, err := d.undoFile(NewGroupDir(d, group).getMetafilename())
+			if err != nil {
+				return nil, err
+			}
+			undos = append(undos, undo)
+		}
+		return undoAll(undos...), nil
+	}, func() (*AuditRecord, error) {
+		var err error
+		if assigned, err = d.assignGIDs(firstGID); err != nil {
+			return nil, err
+		}
+		if len(assigned) == 0 {
+			return nil, nil
+		}
+		return d.newAuditRecord(AuditAssignGIDs, "", "", nil, assigned), nil
+	})
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (d *Dir) assignGIDs(firstGID int) (map[string]int, error) {
+	groups, err := d.listGroups()
+	if err != nil {
+		return nil, err
+	}
+	last, err := d.lastGID()
+	if err != nil {
+		return nil, err
+	}
+	next := firstGID
+	if last >= next {
+		next = last + 1
+	}
+	metas := make(map[string]*GroupMeta)
+	for _, group := range groups {
+		meta, err := NewGroupDir(d, group).GetMeta()
+		if err != nil {
+			return nil, err
+		}
+		metas[group] = meta
+		if meta.GID != nil && *meta.GID >= next {
+			next = *meta.GID + 1
+		}
+	}
+
+	assigned := make(map[string]int)
+	for _, group := range groups {
+		if metas[group].GID != nil {
+			continue
+		}
+		metas[group].GID = newGID(next)
+		if err := NewGroupDir(d, group).SetMeta(metas[group]); err != nil {
+			return nil, err
+		}
+		assigned[group] = next
+		next++
+	}
+	return assigned, nil
+}
+
+// usedGIDs returns the names of all groups which have a GID by GID.
+func (d *Dir) usedGIDs() (map[int]string, error) {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return d.readGIDs()
+}
+
+// readGIDs is like usedGIDs but expects the caller to hold the lock.
+func (d *Dir) readGIDs() (map[int]string, error) {
+	groups, err := d.listGroups()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[int]string)
+	for _, group := range groups {
+		meta, err := NewGroupDir(d, group).GetMeta()
+		if err != nil {
+			return nil, err
+		}
+		if meta.GID != nil {
+			used[*meta.GID] = group
+		}
+	}
+	return used, nil
+}
+
+// WriteEtcGroup writes all groups in the format of /etc/group to w, ordered by
+// GID. The member list of each group contains its effective members including
+// members of nested groups. All groups must have a GID, see AssignGIDs.
+// Implicit user groups are not written.
+func (d *Dir) WriteEtcGroup(w io.Writer) error {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	groups, err := d.listGroups()
+	if err != nil {
+		return err
+	}
+	owners := make(map[int]string)
+	for _, group := range groups {
+		meta, err := NewGroupDir(d, group).GetMeta()
+		if err != nil {
+			return err
+		}
+		if meta.GID == nil {
+			return fmt.Errorf("whawty.groups.store: group '%s' has no gid", group)
+		}
+		if owner, exists := owners[*meta.GID]; exists {
+			return fmt.Errorf("whawty.groups.store: gid %d is used by group '%s' and '%s'", *meta.GID, owner, group)
+		}
+		owners[*meta.GID] = group
+	}
+	sorted := make([]int, 0, len(owners))
+	for gid := range owners {
+		sorted = append(sorted, gid)
+	}
+	sort.Ints(sorted)
+
+	bw := bufio.NewWriter(w)
+	for _, gid := range sorted {
+		group := owners[gid]
+		members, err := d.effectiveMembers(group)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "%s:x:%d:%s\n", group, gid, strings.Join(members, ","))
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,192 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestAssignGIDs(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, group := range []string{"devs", "admins", "ops"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if err := store.SetGroupMeta("ops", &GroupMeta{GID: newGID(20000)}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	assigned, err := store.AssignGIDs(0)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if expected := map[string]int{"admins": 20001, "devs": 20002}; !reflect.DeepEqual(assigned, expected) {
+		t.Fatalf("AssignGIDs returned %v, expected %v", assigned, expected)
+	}
+
+	if err := store.AddGroup("users"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, err := store.RemoveGroup("devs", false); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if assigned, err = store.AssignGIDs(0); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	// the GID of the removed group 'devs' must not be reused
+	if expected := map[string]int{"users": 20003}; !reflect.DeepEqual(assigned, expected) {
+		t.Fatalf("AssignGIDs returned %v, expected %v", assigned, expected)
+	}
+	if meta, err := store.GetGroupMeta("admins"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if meta.GID == nil || *meta.GID != 20001 {
+		t.Fatalf("GID of 'admins' changed to %v", meta.GID)
+	}
+
+	if assigned, err = store.AssignGIDs(0); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(assigned) != 0 {
+		t.Fatalf("AssignGIDs should not assign any GIDs, got %v", assigned)
+	}
+
+	if _, err := store.RemoveGroup("users", false); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroup("guests"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if assigned, err = store.AssignGIDs(0); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if expected := map[string]int{"guests": 20004}; !reflect.DeepEqual(assigned, expected) {
+		t.Fatalf("AssignGIDs returned %v, expected %v", assigned, expected)
+	}
+
+	if err := store.AddGroup("apps"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.SetGroupMeta("apps", &GroupMeta{GID: newGID(20001)}); err == nil {
+		t.Fatal("setting a GID which is used by another group should throw an error")
+	} else if gerr, ok := cause(err).(*GIDError); !ok || gerr.Group != "admins" {
+		t.Fatalf("error should report the group using the GID, got: %v", err)
+	}
+	if err := store.SetGroupMeta("apps", &GroupMeta{GID: newGID(20002)}); err == nil {
+		t.Fatal("setting the GID of a removed group should throw an error")
+	} else if gerr, ok := cause(err).(*GIDError); !ok || gerr.Group != "" {
+		t.Fatalf("error should report a GID which is not higher than the last one, got: %v", err)
+	}
+	if meta, err := store.GetGroupMeta("apps"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if meta.GID != nil {
+		t.Fatalf("failed SetGroupMeta must not assign a GID, got %d", *meta.GID)
+	}
+	if err := store.SetGroupMeta("apps", &GroupMeta{GID: newGID(20010)}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.Check(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestWriteEtcGroup(t *testing.T) {
+	store := NewDirWithOptions(testBaseDir, Options{ImplicitUserGroups: true})
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	tx := store.Begin()
+	tx.AddGroup("company")
+	tx.AddGroup("devs")
+	tx.AddGroup("empty")
+	tx.AddGroupMember("company", "devs")
+	for _, user := range []string{"carol", "alice", "bob"} {
+		tx.AddUser(user)
+	}
+	tx.AddUserMember("devs", "bob")
+	tx.AddUserMember("devs", "alice")
+	tx.AddUserMember("company", "carol")
+	tx.AddUserMember("company", "bob")
+	if err := tx.Commit(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var buf bytes.Buffer
+	if err := store.WriteEtcGroup(&buf); err == nil {
+		t.Fatal("writing groups without GID should throw an error")
+	}
+
+	if _, err := store.AssignGIDs(500); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	buf.Reset()
+	if err := store.WriteEtcGroup(&buf); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := "company:x:500:alice,bob,carol\ndevs:x:501:alice,bob\nempty:x:502:\n"
+	if buf.String() != expected {
+		t.Fatalf("WriteEtcGroup wrote:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	if err := store.SetGroupMeta("empty", &GroupMeta{Description: "no members", GID: newGID(500)}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if meta, err := store.GetGroupMeta("empty"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if meta.GID == nil || *meta.GID != 502 || meta.Description != "no members" {
+		t.Fatalf("SetGroupMeta must keep the assigned GID, got %+v", meta)
+	}
+
+	if err := NewGroupDir(store, "empty").writeMeta(&GroupMeta{GID: newGID(500)}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.WriteEtcGroup(&buf); err == nil {
+		t.Fatal("writing groups with duplicate GIDs should throw an error")
+	}
+}
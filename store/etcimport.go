@@ -0,0 +1,248 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EtcRejection describes an entry of a passwd or group file which was not
+// imported by ImportEtc. For rejected memberships Name is the name of the
+// member.
+type EtcRejection struct {
+	File string
+	Line int
+	Name string
+	Err  error
+}
+
+func (r EtcRejection) String() string {
+	return fmt.Sprintf("%s:%d: %s: %v", r.File, r.Line, r.Name, r.Err)
+}
+
+// EtcImportResult contains the names of the users and groups created by
+// ImportEtc as well as all entries which were rejected.
+type EtcImportResult struct {
+	Users    []string
+	Groups   []string
+	Rejected []EtcRejection
+}
+
+func (r *EtcImportResult) reject(file string, line int, name string, err error) {
+	r.Rejected = append(r.Rejected, EtcRejection{file, line, name, err})
+}
+
+// etcEntry is a single line of a passwd or group file split into its fields.
+type etcEntry struct {
+	line   int
+	fields []string
+}
+
+// readEtcFile reads all entries of a passwd or group style file. Lines which
+// don't have n fields are rejected.
+func readEtcFile(r io.Reader, file string, n int, result *EtcImportResult) ([]etcEntry, error) {
+	var entries []etcEntry
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != n {
+			result.reject(file, lineno, fields[0], fmt.Errorf("expected %d fields, got %d", n, len(fields)))
+			continue
+		}
+		entries = append(entries, etcEntry{lineno, fields})
+	}
+	return entries, scanner.Err()
+}
+
+// gecosToMeta converts the GECOS field of a passwd entry to user meta data. The
+// full name is split into first and last name, the room number, phone numbers
+// and other information end up in Extra. An ampersand in the full name gets
+// replaced by the capitalized user name.
+func gecosToMeta(user, gecos string) *UserMeta {
+	meta := &UserMeta{}
+	fields := strings.Split(gecos, ",")
+	if len(user) > 0 {
+		fields[0] = strings.Replace(fields[0], "&", strings.ToUpper(user[:1])+user[1:], -1)
+	}
+	if names := strings.Fields(fields[0]); len(names) > 1 {
+		meta.FirstName = strings.Join(names[:len(names)-1], " ")
+		meta.LastName = names[len(names)-1]
+	} else if len(names) == 1 {
+		meta.FirstName = names[0]
+	}
+	for i, key := range []string{"room", "workphone", "homephone", "other"} {
+		if i+1 < len(fields) && strings.TrimSpace(fields[i+1]) != "" {
+			if meta.Extra == nil {
+				meta.Extra = make(map[string]interface{})
+			}
+			meta.Extra[key] = strings.TrimSpace(fields[i+1])
+		}
+	}
+	return meta
+}
+
+// ImportEtc imports users from passwd and groups from group, both in the format
+// of the files in /etc. Either of them may be nil. The GECOS field of users is
+// stored as meta data, see gecosToMeta, and the GID of groups is kept. Users
+// are added as members of the groups listed in group as well as of their
+// primary group. Entries which can't be imported, e.g. because their name is
+// invalid or their name or GID is already in use, are reported in the result
+// and don't stop the import. Every user, group and membership is added on its
+// own so an error other than a rejected entry leaves the store partially
+// imported.
+func (d *Dir) ImportEtc(passwd, group io.Reader) (*EtcImportResult, error) {
+	result := &EtcImportResult{Users: []string{}, Groups: []string{}}
+
+	var users, groups []etcEntry
+	var err error
+	if passwd != nil {
+		if users, err = readEtcFile(passwd, "passwd", 7, result); err != nil {
+			return nil, err
+		}
+	}
+	if group != nil {
+		if groups, err = readEtcFile(group, "group", 4, result); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, u := range users {
+		name := u.fields[0]
+		if err := d.AddUser(name); err != nil {
+			result.reject("passwd", u.line, name, err)
+			continue
+		}
+		result.Users = append(result.Users, name)
+		if gecos := u.fields[4]; gecos != "" {
+			if err := d.SetUserMeta(name, gecosToMeta(name, gecos)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	gids, err := d.usedGIDs()
+	if err != nil {
+		return nil, err
+	}
+	imported := make(map[int]string)
+	for _, g := range groups {
+		name := g.fields[0]
+		gid, err := strconv.Atoi(g.fields[2])
+		if err != nil || gid < 0 {
+			result.reject("group", g.line, name, fmt.Errorf("invalid gid '%s'", g.fields[2]))
+			continue
+		}
+		if owner, exists := gids[gid]; exists {
+			result.reject("group", g.line, name, fmt.Errorf("gid %d is already used by group '%s'", gid, owner))
+			continue
+		}
+		if err := d.AddGroup(name); err != nil {
+			result.reject("group", g.line, name, err)
+			continue
+		}
+		result.Groups = append(result.Groups, name)
+		gids[gid] = name
+		imported[gid] = name
+		if err := d.setImportedGID(name, gid); err != nil {
+			return nil, err
+		}
+		if g.fields[3] == "" {
+			continue
+		}
+		for _, member := range strings.Split(g.fields[3], ",") {
+			if !nameRe.MatchString(member) {
+				result.reject("group", g.line, member, &InvalidNameError{"user", member})
+				continue
+			}
+			if err := d.AddUserMember(name, member); err != nil {
+				result.reject("group", g.line, member, err)
+			}
+		}
+	}
+
+	for _, u := range users {
+		gid, err := strconv.Atoi(u.fields[3])
+		if err != nil {
+			continue
+		}
+		group, exists := imported[gid]
+		if !exists || !contains(result.Users, u.fields[0]) {
+			continue
+		}
+		if err := d.AddUserMember(group, u.fields[0]); err != nil {
+			result.reject("passwd", u.line, u.fields[0], err)
+		}
+	}
+	return result, nil
+}
+
+// setImportedGID sets the GID of group to gid. Unlike SetGroupMeta it accepts
+// GIDs which are not higher than the highest GID ever assigned, the GIDs of
+// imported groups have been assigned by the system they are imported from.
+func (d *Dir) setImportedGID(group string, gid int) error {
+	tx := d.Begin()
+	tx.stage(func() (func() error, error) {
+		undoLastGID, err := d.undoFile(filepath.Join(d.basedir, lastGIDFile))
+		if err != nil {
+			return nil, err
+		}
+		undoMeta, err := d.undoFile(NewGroupDir(d, group).getMetafilename())
+		if err != nil {
+			return nil, err
+		}
+		return undoAll(undoMeta, undoLastGID), nil
+	}, func() (*AuditRecord, error) {
+		g := NewGroupDir(d, group)
+		before, err := g.GetMeta()
+		if err != nil {
+			return nil, err
+		}
+		after := *before
+		after.GID = newGID(gid)
+		after.Changed = time.Now()
+		if err := g.writeMeta(&after); err != nil {
+			return nil, err
+		}
+		before.Extra, after.Extra = jsonExtra(before.Extra), jsonExtra(after.Extra)
+		return d.newAuditRecord(AuditSetGroupMeta, group, "", before, &after), nil
+	})
+	return tx.Commit()
+}
@@ -0,0 +1,193 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testPasswd = `root:x:0:0:root:/root:/bin/bash
+# comment
+hugo:x:1000:1000:Hugo Huber,42,+43 1 234,,hugo@example.com:/home/hugo:/bin/bash
+fredl:x:1001:100:& Fischer:/home/fredl:/bin/sh
+-invalid:x:1002:100::/home/invalid:/bin/sh
+broken:x:1003
+`
+	testGroup = `root:x:0:
+users:x:100:hugo,unknown
+hugo:x:1000:
+admins:x:27:hugo,fredl,-invalid
+nogid:x:abc:
+`
+)
+
+func TestImportEtc(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	result, err := store.ImportEtc(strings.NewReader(testPasswd), strings.NewReader(testGroup))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if expected := []string{"root", "hugo", "fredl"}; !reflect.DeepEqual(result.Users, expected) {
+		t.Fatalf("ImportEtc created users %v, expected %v", result.Users, expected)
+	}
+	if expected := []string{"users", "admins"}; !reflect.DeepEqual(result.Groups, expected) {
+		t.Fatalf("ImportEtc created groups %v, expected %v", result.Groups, expected)
+	}
+	rejected := make([]string, 0, len(result.Rejected))
+	for _, r := range result.Rejected {
+		rejected = append(rejected, r.File+":"+r.Name)
+	}
+	expected := []string{"passwd:broken", "passwd:-invalid", "group:root", "group:unknown", "group:hugo", "group:-invalid", "group:nogid"}
+	if !reflect.DeepEqual(rejected, expected) {
+		t.Fatalf("ImportEtc rejected %v, expected %v", rejected, expected)
+	}
+
+	if meta, err := store.GetUserMeta("hugo"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if meta.FirstName != "Hugo" || meta.LastName != "Huber" || meta.Extra["room"] != "42" ||
+		meta.Extra["workphone"] != "+43 1 234" || meta.Extra["other"] != "hugo@example.com" {
+		t.Fatalf("wrong meta data for 'hugo': %+v", meta)
+	}
+	if meta, err := store.GetUserMeta("fredl"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if meta.FirstName != "Fredl" || meta.LastName != "Fischer" || meta.Extra != nil {
+		t.Fatalf("wrong meta data for 'fredl': %+v", meta)
+	}
+	if meta, err := store.GetGroupMeta("admins"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if meta.GID == nil || *meta.GID != 27 {
+		t.Fatalf("wrong gid for 'admins': %v", meta.GID)
+	}
+
+	if members, err := store.EffectiveMembers("users"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"fredl", "hugo"}; !reflect.DeepEqual(members, expected) {
+		t.Fatalf("wrong members of 'users', expected %v, got %v", expected, members)
+	}
+	if members, err := store.EffectiveMembers("admins"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"fredl", "hugo"}; !reflect.DeepEqual(members, expected) {
+		t.Fatalf("wrong members of 'admins', expected %v, got %v", expected, members)
+	}
+}
+
+func TestImportEtcGIDZero(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	result, err := store.ImportEtc(nil, strings.NewReader("wheel:x:0:\nstaff:x:50:\n"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(result.Rejected) != 0 {
+		t.Fatalf("ImportEtc rejected %v", result.Rejected)
+	}
+	if err := store.AddGroup("devs"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if assigned, err := store.AssignGIDs(0); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := map[string]int{"devs": DefaultFirstGID}; !reflect.DeepEqual(assigned, expected) {
+		t.Fatalf("AssignGIDs returned %v, expected %v", assigned, expected)
+	}
+	var buf bytes.Buffer
+	if err := store.WriteEtcGroup(&buf); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if expected := "wheel:x:0:\nstaff:x:50:\ndevs:x:10000:\n"; buf.String() != expected {
+		t.Fatalf("WriteEtcGroup wrote:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestImportEtcDuplicateGID(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroup("staff"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.SetGroupMeta("staff", &GroupMeta{GID: newGID(50)}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	result, err := store.ImportEtc(nil, strings.NewReader("users:x:100:\nother:x:100:\nemployees:x:50:\n"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if expected := []string{"users"}; !reflect.DeepEqual(result.Groups, expected) {
+		t.Fatalf("ImportEtc created groups %v, expected %v", result.Groups, expected)
+	}
+	rejected := make([]string, 0, len(result.Rejected))
+	for _, r := range result.Rejected {
+		rejected = append(rejected, r.Name)
+	}
+	if expected := []string{"other", "employees"}; !reflect.DeepEqual(rejected, expected) {
+		t.Fatalf("ImportEtc rejected %v, expected %v", rejected, expected)
+	}
+
+	var buf bytes.Buffer
+	if err := store.WriteEtcGroup(&buf); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if expected := "staff:x:50:\nusers:x:100:\n"; buf.String() != expected {
+		t.Fatalf("WriteEtcGroup wrote:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
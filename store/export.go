@@ -0,0 +1,294 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ExportVersion is the version of the document written by Export. Import only
+// accepts documents of this version.
+const ExportVersion = 1
+
+// exportDocument is the JSON document written by Export and read by Import.
+type exportDocument struct {
+	Version int                     `json:"version"`
+	LastGID int                     `json:"lastgid,omitempty"`
+	Users   map[string]*UserMeta    `json:"users"`
+	Groups  map[string]*exportGroup `json:"groups"`
+}
+
+// exportGroup contains the meta data and the direct members of a group.
+type exportGroup struct {
+	Meta   *GroupMeta `json:"meta"`
+	Users  []string   `json:"users"`
+	Groups []string   `json:"groups"`
+}
+
+// Export writes all users and groups including their meta data and all direct
+// memberships to w as a JSON document. Implicit user groups and dangling
+// membership links are not exported.
+func (d *Dir) Export(w io.Writer) error {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	doc := &exportDocument{
+		Version: ExportVersion,
+		Users:   make(map[string]*UserMeta),
+		Groups:  make(map[string]*exportGroup),
+	}
+	if doc.LastGID, err = d.lastGID(); err != nil {
+		return err
+	}
+
+	users, err := d.listUsers()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		meta, err := NewUserFile(d, user).Get()
+		if err != nil {
+			return err
+		}
+		meta.Extra = jsonExtra(meta.Extra)
+		doc.Users[user] = meta
+	}
+
+	groups, err := d.listGroups()
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		g := NewGroupDir(d, group)
+		meta, err := g.GetMeta()
+		if err != nil {
+			return err
+		}
+		meta.Extra = jsonExtra(meta.Extra)
+		users, groups, err := g.readMembers()
+		if err != nil {
+			return err
+		}
+		if users == nil {
+			users = []string{}
+		}
+		if groups == nil {
+			groups = []string{}
+		}
+		doc.Groups[group] = &exportGroup{meta, users, groups}
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Import reads a JSON document written by Export from r and recreates all users,
+// groups and memberships it contains. The meta data, including the changed
+// timestamps, is kept as it is. The store must be initialized and must not
+// contain any users or groups. The document is validated before anything is
+// written and if an error occurs all changes are reverted.
+func (d *Dir) Import(r io.Reader) error {
+	doc := &exportDocument{}
+	if err := json.NewDecoder(r).Decode(doc); err != nil {
+		return fmt.Errorf("whawty.groups.store: can't parse export document: %v", err)
+	}
+	if doc.Version != ExportVersion {
+		return fmt.Errorf("whawty.groups.store: export document version %d is not supported", doc.Version)
+	}
+	if err := doc.validate(); err != nil {
+		return err
+	}
+
+	tx := d.Begin()
+	tx.stage(func() (func() error, error) {
+		users, err := d.listUsers()
+		if err != nil {
+			return nil, err
+		}
+		groups, err := d.listGroups()
+		if err != nil {
+			return nil, err
+		}
+		if len(users) > 0 || len(groups) > 0 {
+			return nil, fmt.Errorf("whawty.groups.store: can't import into '%s', the store is not empty", d.basedir)
+		}
+		undoLastGID, err := d.undoFile(filepath.Join(d.basedir, lastGIDFile))
+		if err != nil {
+			return nil, err
+		}
+		return undoAll(d.removeAll, undoLastGID), nil
+	}, func() (*AuditRecord, error) {
+		if err := d.importDocument(doc); err != nil {
+			return nil, err
+		}
+		after := map[string]int{"users": len(doc.Users), "groups": len(doc.Groups)}
+		return d.newAuditRecord(AuditImport, "", "", nil, after), nil
+	})
+	return tx.Commit()
+}
+
+// validate checks that all names are valid, users and groups don't share a name,
+// the meta data doesn't use reserved keys in Extra, no GID is used twice and all
+// members exist.
+func (doc *exportDocument) validate() error {
+	for user, meta := range doc.Users {
+		if !nameRe.MatchString(user) {
+			return &InvalidNameError{"user", user}
+		}
+		if meta == nil {
+			return fmt.Errorf("whawty.groups.store: user '%s' has no meta data", user)
+		}
+		if err := checkExtraKeys(meta.Extra, userMetaKeys); err != nil {
+			return fmt.Errorf("whawty.groups.store: invalid meta data of user '%s': %v", user, err)
+		}
+	}
+	gids := make(map[int]string)
+	for group, g := range doc.Groups {
+		if !nameRe.MatchString(group) {
+			return &InvalidNameError{"group", group}
+		}
+		if _, exists := doc.Users[group]; exists {
+			return &ExistError{"group", group, "user"}
+		}
+		if g == nil || g.Meta == nil {
+			return fmt.Errorf("whawty.groups.store: group '%s' has no meta data", group)
+		}
+		if err := checkExtraKeys(g.Meta.Extra, groupMetaKeys); err != nil {
+			return fmt.Errorf("whawty.groups.store: invalid meta data of group '%s': %v", group, err)
+		}
+		if g.Meta.GID != nil {
+			if owner, exists := gids[*g.Meta.GID]; exists {
+				if owner > group {
+					owner, group = group, owner
+				}
+				return fmt.Errorf("whawty.groups.store: gid %d is used by group '%s' and '%s'", *g.Meta.GID, owner, group)
+			}
+			gids[*g.Meta.GID] = group
+		}
+		for _, user := range g.Users {
+			if _, exists := doc.Users[user]; !exists {
+				return fmt.Errorf("whawty.groups.store: member '%s' of group '%s' is not a user", user, group)
+			}
+		}
+		for _, member := range g.Groups {
+			if _, exists := doc.Groups[member]; !exists {
+				return fmt.Errorf("whawty.groups.store: member '%s' of group '%s' is not a group", member, group)
+			}
+			if member == group {
+				return &SelfMemberError{group}
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Dir) importDocument(doc *exportDocument) error {
+	if err := d.raiseLastGID(doc.LastGID); err != nil {
+		return err
+	}
+
+	users := make([]string, 0, len(doc.Users))
+	for user := range doc.Users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		if err := NewUserFile(d, user).writeMeta(doc.Users[user]); err != nil {
+			return err
+		}
+	}
+
+	groups := make([]string, 0, len(doc.Groups))
+	for group := range doc.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		g := doc.Groups[group]
+		if g.Meta.GID != nil {
+			if err := d.raiseLastGID(*g.Meta.GID); err != nil {
+				return err
+			}
+		}
+		meta, err := yaml.Marshal(g.Meta)
+		if err != nil {
+			return err
+		}
+		links := make(map[string]string)
+		for _, user := range g.Users {
+			links[user] = userLinkTarget(user)
+		}
+		for _, member := range g.Groups {
+			links[member] = groupLinkTarget(member)
+		}
+		if err := NewGroupDir(d, group).create(meta, links); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAll removes all users and groups from the store.
+func (d *Dir) removeAll() error {
+	users, err := d.listUsers()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := NewUserFile(d, user).Remove(); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	groups, err := d.listGroups()
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if err := os.RemoveAll(NewGroupDir(d, group).getDirname()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	tx := store.Begin()
+	tx.AddGroup("company")
+	tx.AddGroup("devs")
+	tx.AddGroup("empty")
+	tx.AddGroupMember("company", "devs")
+	for _, user := range []string{"alice", "bob"} {
+		tx.AddUser(user)
+		tx.AddUserMember("devs", user)
+	}
+	tx.AddUser("carol")
+	tx.AddUserMember("company", "carol")
+	tx.SetUserMeta("alice", &UserMeta{FirstName: "Alice", Mail: "alice@example.com",
+		Extra: map[string]interface{}{"shell": "/bin/zsh", "keys": []interface{}{"a", "b"}}})
+	tx.SetGroupMeta("devs", &GroupMeta{DisplayName: "Developers", Description: "all developers"})
+	if err := tx.Commit(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if _, err := store.AssignGIDs(0); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, err := store.RemoveGroup("empty", false); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var buf bytes.Buffer
+	if err := store.Export(&buf); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	before := snapshotStore(t, testBaseDir)
+
+	if err := store.Import(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatal("importing into a non-empty store should throw an error")
+	}
+
+	if err := os.RemoveAll(testBaseDir); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.Import(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if after := snapshotStore(t, testBaseDir); !reflect.DeepEqual(before, after) {
+		t.Fatalf("imported store differs from exported store:\n%v\n%v", before, after)
+	}
+	if last, err := store.lastGID(); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if last != DefaultFirstGID+2 {
+		t.Fatalf("import should keep the highest GID ever assigned, got %d", last)
+	}
+	if err := store.Check(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	documents := []string{
+		`not json`,
+		`{"version": 2, "users": {}, "groups": {}}`,
+		`{"version": 1, "users": {"-hugo": {}}, "groups": {}}`,
+		`{"version": 1, "users": {"hugo": {}}, "groups": {"hugo": {"meta": {}}}}`,
+		`{"version": 1, "users": {"hugo": {"extra": {"mail": "x"}}}, "groups": {}}`,
+		`{"version": 1, "users": {}, "groups": {"admins": {"meta": {"extra": {"gid": 1}}}}}`,
+		`{"version": 1, "users": {}, "groups": {"admins": {"meta": {"gid": 5}}, "devs": {"meta": {"gid": 5}}}}`,
+		`{"version": 1, "users": {}, "groups": {"admins": {"meta": {}, "users": ["hugo"]}}}`,
+		`{"version": 1, "users": {}, "groups": {"admins": {"meta": {}, "groups": ["devs"]}}}`,
+		`{"version": 1, "users": {}, "groups": {"admins": {"meta": {}, "groups": ["admins"]}}}`,
+	}
+	for _, doc := range documents {
+		if err := store.Import(strings.NewReader(doc)); err == nil {
+			t.Fatalf("importing '%s' should throw an error", doc)
+		}
+	}
+	if users, err := store.ListUsers(""); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if len(users) != 0 {
+		t.Fatalf("failed imports must not change the store, found users %v", users)
+	}
+}
@@ -0,0 +1,104 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const gitDir string = ".git"
+
+// runGit runs git with args inside the base directory. The actor of the store
+// is used as author and committer.
+func (d *Dir) runGit(args ...string) error {
+	email := d.opts.Actor
+	if hostname, err := os.Hostname(); err == nil {
+		email += "@" + hostname
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = d.basedir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+d.opts.Actor, "GIT_AUTHOR_EMAIL="+email,
+		"GIT_COMMITTER_NAME="+d.opts.Actor, "GIT_COMMITTER_EMAIL="+email)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("whawty.groups.store: git %s failed: %v: %s", args[0], err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// gitInit creates a git repository inside the base directory. The lock file and
+// the temporary directory are excluded from the repository.
+func (d *Dir) gitInit() error {
+	if err := d.runGit("init", "-q"); err != nil {
+		return err
+	}
+	exclude := fmt.Sprintf("/%s\n/%s/\n", lockFile, tmpDir)
+	infoDir := filepath.Join(d.basedir, gitDir, "info")
+	if err := os.MkdirAll(infoDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(infoDir, "exclude"), []byte(exclude), 0644)
+}
+
+// gitCommit commits the current state of the store. The commit message is
+// generated from records. It does nothing unless git mode is enabled.
+func (d *Dir) gitCommit(records []*AuditRecord) error {
+	if !d.opts.Git || len(records) == 0 {
+		return nil
+	}
+	if err := d.runGit("add", "-A", "."); err != nil {
+		return err
+	}
+	return d.runGit("commit", "-q", "--allow-empty", "-m", gitMessage(records))
+}
+
+// gitMessage generates a commit message describing records.
+func gitMessage(records []*AuditRecord) string {
+	if len(records) == 1 {
+		return records[0].String()
+	}
+	lines := make([]string, 0, len(records)+2)
+	lines = append(lines, fmt.Sprintf("%d changes", len(records)), "")
+	for _, r := range records {
+		lines = append(lines, r.String())
+	}
+	return strings.Join(lines, "\n")
+}
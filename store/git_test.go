@@ -0,0 +1,110 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func gitOutput(t *testing.T, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = testBaseDir
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitMode(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	store := NewDirWithOptions(testBaseDir, Options{Actor: "admin", Git: true})
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUser("hugo"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroup("admins"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	tx := store.Begin()
+	tx.AddUser("fredl")
+	tx.AddUserMember("admins", "hugo")
+	tx.AddUserMember("admins", "fredl")
+	if err := tx.Commit(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroup("hugo"); err == nil {
+		t.Fatal("adding a group with the name of a user should throw an error")
+	}
+
+	subjects := strings.Split(gitOutput(t, "log", "--format=%s"), "\n")
+	expected := []string{"3 changes", "add-group admins", "add-user hugo", "init"}
+	if len(subjects) != len(expected) {
+		t.Fatalf("git log returned %q, expected %q", subjects, expected)
+	}
+	for i := range expected {
+		if subjects[i] != expected[i] {
+			t.Fatalf("git log returned %q, expected %q", subjects, expected)
+		}
+	}
+	if author := gitOutput(t, "log", "-1", "--format=%an"); author != "admin" {
+		t.Fatalf("commit author is '%s', expected 'admin'", author)
+	}
+	if status := gitOutput(t, "status", "--porcelain"); status != "" {
+		t.Fatalf("working tree is not clean: %s", status)
+	}
+	files := gitOutput(t, "ls-files")
+	for _, name := range []string{lockFile, tmpDir} {
+		if strings.Contains(files, name) {
+			t.Fatalf("'%s' should not be tracked by git", name)
+		}
+	}
+
+	if err := store.Check(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := NewDir(testBaseDir).Check(); err == nil {
+		t.Fatal("Check should report the git repository if git mode is disabled")
+	}
+}
@@ -32,8 +32,10 @@ package store
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -62,37 +64,195 @@ func (g *GroupDir) getMetafilename() string {
 	return filepath.Join(g.store.basedir, groupsDir, g.group, groupMetaFile)
 }
 
+// GroupMeta contains the meta data of a group as stored in the group's meta data
+// file. All fields which are not known are stored in Extra. GID is nil unless a
+// GID has been assigned to the group, 0 is a valid GID.
+type GroupMeta struct {
+	DisplayName string                 `yaml:"displayname,omitempty" json:"displayname,omitempty"`
+	Description string                 `yaml:"description,omitempty" json:"description,omitempty"`
+	ContactMail string                 `yaml:"contactmail,omitempty" json:"contactmail,omitempty"`
+	GID         *int                   `yaml:"gid,omitempty" json:"gid,omitempty"`
+	Changed     time.Time              `yaml:"changed" json:"changed"`
+	Extra       map[string]interface{} `yaml:",inline" json:"extra,omitempty"`
+}
+
+// groupMetaKeys are the keys of the group meta data file which are stored in
+// the fields of GroupMeta. They must not be used in Extra.
+var groupMetaKeys = []string{"displayname", "description", "contactmail", "gid", "changed"}
+
+// newGID returns a pointer to gid for use in GroupMeta.
+func newGID(gid int) *int {
+	return &gid
+}
+
 // Add creates the group directory. It is an error if the group already exists.
 func (g *GroupDir) Add() (err error) {
 	var exists bool
 	if exists, err = g.Exists(); err != nil {
 		return
 	} else if exists {
-		return fmt.Errorf("whawty.groups.store: group '%s' already exists", g.group)
+		return &ExistError{"group", g.group, "group"}
 	}
 
-	if err = os.Mkdir(g.getDirname(), 0755); err != nil {
+	var data []byte
+	if data, err = yaml.Marshal(&GroupMeta{Changed: time.Now()}); err != nil {
 		return
 	}
+	return g.create(data, nil)
+}
 
-	var file *os.File
-	file, err = os.Create(g.getMetafilename())
-	defer file.Close()
+// create creates the group directory containing a meta data file with the
+// contents meta and a symlink for each entry of links. The group directory
+// gets prepared inside the temporary directory and then renamed so that it
+// never shows up partially populated.
+func (g *GroupDir) create(meta []byte, links map[string]string) (err error) {
+	var tmp string
+	if tmp, err = g.store.getTempDir(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmp)
+		}
+	}()
+	if err = os.Chmod(tmp, 0755); err != nil {
+		return
+	}
 
-	m := make(map[string]interface{})
-	m["changed"] = time.Now()
+	if err = g.store.writeFile(filepath.Join(tmp, groupMetaFile), meta, 0644); err != nil {
+		return
+	}
+	for member, target := range links {
+		if err = os.Symlink(target, filepath.Join(tmp, member)); err != nil {
+			return
+		}
+	}
+	if err = os.Rename(tmp, g.getDirname()); err != nil {
+		return
+	}
+	return syncDir(filepath.Dir(g.getDirname()))
+}
+
+// addMetaFile creates the meta data file of the group.
+func (g *GroupDir) addMetaFile() error {
+	return g.writeMeta(&GroupMeta{Changed: time.Now()})
+}
+
+// writeMeta writes the meta data file of the group. If meta contains a GID it
+// gets recorded as used, see Dir.AssignGIDs.
+func (g *GroupDir) writeMeta(meta *GroupMeta) error {
+	if err := checkExtraKeys(meta.Extra, groupMetaKeys); err != nil {
+		return err
+	}
+	if meta.GID != nil {
+		if err := g.store.raiseLastGID(*meta.GID); err != nil {
+			return err
+		}
+	}
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return g.store.writeFile(g.getMetafilename(), data, 0644)
+}
+
+// GetMeta reads the meta data of the group. It is an error if the group does
+// not exist.
+func (g *GroupDir) GetMeta() (meta *GroupMeta, err error) {
+	if err = g.checkExists(); err != nil {
+		return
+	}
 	var data []byte
-	if data, err = yaml.Marshal(m); err != nil {
+	if data, err = ioutil.ReadFile(g.getMetafilename()); err != nil {
 		return
 	}
-	file.Write(data)
+	meta = &GroupMeta{}
+	if err = yaml.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("whawty.groups.store: can't parse meta data of group '%s': %v", g.group, err)
+	}
+	return
+}
+
+// SetMeta replaces the meta data of the group with meta. The changed timestamp
+// is set to the current time. Once a GID has been assigned to the group it is
+// kept, the GID in meta is ignored in this case. Otherwise the GID in meta must
+// neither be used by another group nor be lower or equal to the highest GID ever
+// assigned. It is an error if the group does not exist.
+func (g *GroupDir) SetMeta(meta *GroupMeta) error {
+	if err := g.checkExists(); err != nil {
+		return err
+	}
+	m := *meta
+	if current, err := g.GetMeta(); err == nil && current.GID != nil {
+		m.GID = current.GID
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	} else if m.GID != nil {
+		if err := g.checkNewGID(*m.GID); err != nil {
+			return err
+		}
+	}
+	m.Changed = time.Now()
+	return g.writeMeta(&m)
+}
+
+// checkNewGID checks whether gid may be assigned to the group, see SetMeta.
+func (g *GroupDir) checkNewGID(gid int) error {
+	gids, err := g.store.readGIDs()
+	if err != nil {
+		return err
+	}
+	if owner, used := gids[gid]; used {
+		return &GIDError{gid, owner}
+	}
+	last, err := g.store.lastGID()
+	if err != nil {
+		return err
+	}
+	if last > 0 && gid <= last {
+		return &GIDError{gid, ""}
+	}
 	return nil
 }
 
-// Remove deletes the group directory.
-func (g *GroupDir) Remove() {
-	os.RemoveAll(g.getDirname())
-	return
+// UpdateMeta reads the meta data of the group, calls fn to modify it and
+// writes it back. If fn returns an error the meta data is not changed. The
+// changed timestamp is set to the current time.
+func (g *GroupDir) UpdateMeta(fn func(meta *GroupMeta) error) error {
+	meta, err := g.GetMeta()
+	if err != nil {
+		return err
+	}
+	if err := fn(meta); err != nil {
+		return err
+	}
+	return g.SetMeta(meta)
+}
+
+// Remove deletes the group directory including all membership links.
+func (g *GroupDir) Remove() error {
+	return os.RemoveAll(g.getDirname())
+}
+
+// isEmpty checks whether the group directory contains anything else than the
+// meta data file.
+func (g *GroupDir) isEmpty() (bool, error) {
+	dir, err := openDir(g.getDirname())
+	if err != nil {
+		return false, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(0)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range names {
+		if name != groupMetaFile {
+			return false, nil
+		}
+	}
+	return true, nil
 }
 
 // Exists checks if group exists.
@@ -100,22 +260,238 @@ func (g *GroupDir) Exists() (exists bool, err error) {
 	return fileExists(g.getDirname())
 }
 
-// AddUserMember adds link to user file
+func (g *GroupDir) getLinkname(member string) string {
+	return filepath.Join(g.store.basedir, groupsDir, g.group, member)
+}
+
+// sameFile returns whether the paths a and b point to the same location. Relative
+// paths are interpreted relative to the current working directory.
+func sameFile(a, b string) bool {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false
+	}
+	return absA == absB
+}
+
+// readLink returns the path the member link points to. Relative link targets
+// are interpreted relative to the group directory.
+func (g *GroupDir) readLink(member string) (string, error) {
+	target, err := os.Readlink(g.getLinkname(member))
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(g.getDirname(), target)
+	}
+	return filepath.Clean(target), nil
+}
+
+// readLinks returns the unmodified targets of all symlinks inside the group
+// directory.
+func (g *GroupDir) readLinks() (map[string]string, error) {
+	dir, err := openDir(g.getDirname())
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	fis, err := dir.Readdir(0)
+	if err != nil {
+		return nil, err
+	}
+	links := make(map[string]string)
+	for _, fi := range fis {
+		if fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if links[fi.Name()], err = os.Readlink(g.getLinkname(fi.Name())); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+// readMembers returns the names of all users and groups the member links inside
+// the group directory point to. Entries which aren't symlinks to an existing user
+// file or group directory of the same store are ignored.
+func (g *GroupDir) readMembers() (users, groups []string, err error) {
+	var dir *os.File
+	if dir, err = openDir(g.getDirname()); err != nil {
+		return
+	}
+	defer dir.Close()
+
+	var names []string
+	if names, err = dir.Readdirnames(0); err != nil {
+		return
+	}
+	sort.Strings(names)
+
+	usersPath := filepath.Join(g.store.basedir, usersDir)
+	groupsPath := filepath.Join(g.store.basedir, groupsDir)
+	for _, name := range names {
+		if name == groupMetaFile {
+			continue
+		}
+		target, err := g.readLink(name)
+		if err != nil {
+			continue
+		}
+		if exists, _ := fileExists(target); !exists {
+			continue
+		}
+		switch parent := filepath.Dir(target); {
+		case sameFile(parent, usersPath):
+			users = append(users, filepath.Base(target))
+		case sameFile(parent, groupsPath):
+			groups = append(groups, filepath.Base(target))
+		}
+	}
+	return users, groups, nil
+}
+
+func (g *GroupDir) checkExists() error {
+	if exists, err := g.Exists(); err != nil {
+		return err
+	} else if !exists {
+		return &NotExistError{"group", g.group}
+	}
+	return nil
+}
+
+// addLink creates a symlink called member inside the group directory which points
+// to target. target must be relative to the group directory. It is not an error
+// if the link already exists and points to the same location.
+func (g *GroupDir) addLink(member, target string) error {
+	if err := g.checkExists(); err != nil {
+		return err
+	}
+
+	linkname := g.getLinkname(member)
+	fi, err := os.Lstat(linkname)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if err = os.Symlink(target, linkname); err != nil {
+			return err
+		}
+		return syncDir(g.getDirname())
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("whawty.groups.store: '%s' in group '%s' exists but is not a symlink", member, g.group)
+	}
+
+	current, err := g.readLink(member)
+	if err != nil {
+		return err
+	}
+	if !sameFile(current, filepath.Join(g.getDirname(), target)) {
+		return fmt.Errorf("whawty.groups.store: '%s' in group '%s' already exists but points to '%s'", member, g.group, current)
+	}
+	return nil
+}
+
+// removeLink removes the symlink called member from the group directory. It is not
+// an error if the link doesn't exist. It is an error if the link points to
+// anything else than target (which must be relative to the group directory).
+func (g *GroupDir) removeLink(member, target string) error {
+	if err := g.checkExists(); err != nil {
+		return err
+	}
+
+	linkname := g.getLinkname(member)
+	fi, err := os.Lstat(linkname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("whawty.groups.store: '%s' in group '%s' exists but is not a symlink", member, g.group)
+	}
+
+	current, err := g.readLink(member)
+	if err != nil {
+		return err
+	}
+	if !sameFile(current, filepath.Join(g.getDirname(), target)) {
+		return fmt.Errorf("whawty.groups.store: '%s' in group '%s' points to '%s'", member, g.group, current)
+	}
+	if err = os.Remove(linkname); err != nil {
+		return err
+	}
+	return syncDir(g.getDirname())
+}
+
+// hasLink checks whether there is a symlink called member inside the group
+// directory which points to target (which must be relative to the group
+// directory). The link may be dangling.
+func (g *GroupDir) hasLink(member, target string) (bool, error) {
+	fi, err := os.Lstat(g.getLinkname(member))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return false, nil
+	}
+
+	current, err := g.readLink(member)
+	if err != nil {
+		return false, err
+	}
+	return sameFile(current, filepath.Join(g.getDirname(), target)), nil
+}
+
+func userLinkTarget(user string) string {
+	return filepath.Join("..", "..", usersDir, user)
+}
+
+func groupLinkTarget(group string) string {
+	return filepath.Join("..", group)
+}
+
+// AddUserMember adds link to user file. It is *not* an error if the link already
+// exists. This does not check if the user exists.
 func (g *GroupDir) AddUserMember(user string) error {
-	return ErrNotImplemented
+	return g.addLink(user, userLinkTarget(user))
 }
 
-// RemoveUserMember removes the link to user file
+// RemoveUserMember removes the link to user file. It is *not* an error if the
+// link does not exist.
 func (g *GroupDir) RemoveUserMember(user string) error {
-	return ErrNotImplemented
+	return g.removeLink(user, userLinkTarget(user))
 }
 
-// AddGroupMember adds link to group dir
+// hasUserLink checks whether there is a link to the user file. In contrast
+// to membership queries this also detects dangling links.
+func (g *GroupDir) hasUserLink(user string) (bool, error) {
+	return g.hasLink(user, userLinkTarget(user))
+}
+
+// AddGroupMember adds link to group dir. It is *not* an error if the link already
+// exists. This does not check if the group to add exists.
 func (g *GroupDir) AddGroupMember(group string) error {
-	return ErrNotImplemented
+	return g.addLink(group, groupLinkTarget(group))
 }
 
-// RemoveGroupMember removes the link to group dir
+// RemoveGroupMember removes the link to group dir. It is *not* an error if the
+// link does not exist.
 func (g *GroupDir) RemoveGroupMember(group string) error {
-	return ErrNotImplemented
+	return g.removeLink(group, groupLinkTarget(group))
+}
+
+// hasGroupLink checks whether there is a link to the group dir. In contrast
+// to membership queries this also detects dangling links.
+func (g *GroupDir) hasGroupLink(group string) (bool, error) {
+	return g.hasLink(group, groupLinkTarget(group))
 }
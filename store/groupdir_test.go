@@ -31,9 +31,12 @@
 package store
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAddRemoveGroup(t *testing.T) {
@@ -87,3 +90,221 @@ func TestExistsGroup(t *testing.T) {
 		t.Fatal("file for test group should exist")
 	}
 }
+
+func TestAddRemoveGroupUserMember(t *testing.T) {
+	groupname := "test-usermember-group"
+	username := "test-usermember-user"
+	linkname := filepath.Join(testBaseDirGroupDir, groupsDir, groupname, username)
+
+	g := NewGroupDir(testStoreGroupDir, groupname)
+	if err := g.AddUserMember(username); err == nil {
+		t.Fatal("adding member to not-existing group should yield an error")
+	}
+	if err := g.RemoveUserMember(username); err == nil {
+		t.Fatal("removing member from not-existing group should yield an error")
+	}
+
+	if err := g.Add(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer g.Remove()
+
+	// the user file doesn't exist so this creates a dangling link
+	if err := g.AddUserMember(username); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := g.AddUserMember(username); err != nil {
+		t.Fatal("adding an already existing dangling link should not yield an error:", err)
+	}
+	if err := g.RemoveUserMember(username); err != nil {
+		t.Fatal("removing a dangling link returned an error:", err)
+	}
+	if _, err := os.Lstat(linkname); !os.IsNotExist(err) {
+		t.Fatal("dangling link does still exist after remove:", err)
+	}
+
+	// pre-existing links with the expected target (i.e. absolute links) are fine
+	abstarget, err := filepath.Abs(filepath.Join(testBaseDirGroupDir, usersDir, username))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := os.Symlink(abstarget, linkname); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := g.AddUserMember(username); err != nil {
+		t.Fatal("adding user with pre-existing absolute link returned an error:", err)
+	}
+	if err := g.RemoveUserMember(username); err != nil {
+		t.Fatal("removing user with pre-existing absolute link returned an error:", err)
+	}
+
+	// pre-existing links to something else must not be touched
+	if err := os.Symlink(filepath.Join("..", "..", usersDir, "someone-else"), linkname); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := g.AddUserMember(username); err == nil {
+		t.Fatal("adding user with pre-existing link to another target should yield an error")
+	}
+	if err := g.RemoveUserMember(username); err == nil {
+		t.Fatal("removing user with pre-existing link to another target should yield an error")
+	}
+	if err := os.Remove(linkname); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// pre-existing files which are not symlinks must not be touched
+	if file, err := os.Create(linkname); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else {
+		file.Close()
+	}
+	if err := g.AddUserMember(username); err == nil {
+		t.Fatal("adding user with pre-existing regular file should yield an error")
+	}
+	if err := g.RemoveUserMember(username); err == nil {
+		t.Fatal("removing user with pre-existing regular file should yield an error")
+	}
+}
+
+func TestAddRemoveGroupGroupMember(t *testing.T) {
+	groupname := "test-groupmember-group"
+	membername := "test-groupmember-member"
+	linkname := filepath.Join(testBaseDirGroupDir, groupsDir, groupname, membername)
+
+	g := NewGroupDir(testStoreGroupDir, groupname)
+	if err := g.AddGroupMember(membername); err == nil {
+		t.Fatal("adding member to not-existing group should yield an error")
+	}
+
+	if err := g.Add(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer g.Remove()
+
+	if err := g.AddGroupMember(membername); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := g.AddGroupMember(membername); err != nil {
+		t.Fatal("adding an already existing link should not yield an error:", err)
+	}
+	if err := g.AddUserMember(membername); err == nil {
+		t.Fatal("adding a user with the same name as a group member should yield an error")
+	}
+	if err := g.RemoveGroupMember(membername); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, err := os.Lstat(linkname); !os.IsNotExist(err) {
+		t.Fatal("link does still exist after remove:", err)
+	}
+
+	if err := g.AddUserMember(membername); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := g.RemoveGroupMember(membername); err == nil {
+		t.Fatal("removing a user member using RemoveGroupMember should yield an error")
+	}
+	if err := g.RemoveUserMember(membername); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestGroupMeta(t *testing.T) {
+	groupname := "test-meta-group"
+
+	g := NewGroupDir(testStoreGroupDir, groupname)
+
+	if _, err := g.GetMeta(); err == nil {
+		t.Fatal("reading meta data of not existing group should yield an error")
+	} else if _, ok := err.(*NotExistError); !ok {
+		t.Fatal("reading meta data of not existing group should yield a NotExistError, got:", err)
+	}
+	if err := g.SetMeta(&GroupMeta{Description: "nothing"}); err == nil {
+		t.Fatal("setting meta data of not existing group should yield an error")
+	}
+	if exists, err := g.Exists(); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if exists {
+		t.Fatal("setting meta data must not create the group")
+	}
+
+	if err := g.Add(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer g.Remove()
+
+	meta, err := g.GetMeta()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if meta.Changed.IsZero() {
+		t.Fatal("newly added group should have a changed timestamp")
+	}
+	added := meta.Changed
+
+	time.Sleep(10 * time.Millisecond)
+	meta.DisplayName = "Test Group"
+	meta.Description = "grants access to the test systems"
+	meta.ContactMail = "admins@example.com"
+	meta.Extra = map[string]interface{}{"ticket": "OPS-42"}
+	if err := g.SetMeta(meta); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if meta, err = g.GetMeta(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, key := range []string{"displayname", "description", "contactmail", "gid", "changed"} {
+		m := *meta
+		m.Extra = map[string]interface{}{key: "x"}
+		if err := g.SetMeta(&m); err == nil {
+			t.Fatalf("setting meta data with reserved key '%s' in Extra should yield an error", key)
+		} else if _, ok := err.(*ReservedKeyError); !ok {
+			t.Fatalf("error should report the reserved key '%s', got: %v", key, err)
+		}
+	}
+	if meta.DisplayName != "Test Group" || meta.Description != "grants access to the test systems" || meta.ContactMail != "admins@example.com" {
+		t.Fatalf("meta data was not stored correctly: %+v", meta)
+	}
+	if meta.Extra["ticket"] != "OPS-42" {
+		t.Fatalf("extra meta data was not stored correctly: %+v", meta.Extra)
+	}
+	if !meta.Changed.After(added) {
+		t.Fatal("SetMeta should update the changed timestamp")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(testBaseDirGroupDir, groupsDir, groupname, groupMetaFile))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, key := range []string{"displayname: Test Group", "contactmail: admins@example.com", "ticket: OPS-42", "changed:"} {
+		if !strings.Contains(string(data), key) {
+			t.Fatalf("meta file should contain '%s':\n%s", key, data)
+		}
+	}
+
+	if err := g.UpdateMeta(func(meta *GroupMeta) error {
+		meta.Description = "grants access to the staging systems"
+		return nil
+	}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if meta, err = g.GetMeta(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if meta.DisplayName != "Test Group" || meta.Description != "grants access to the staging systems" {
+		t.Fatalf("meta data was not updated correctly: %+v", meta)
+	}
+
+	if err := g.UpdateMeta(func(meta *GroupMeta) error {
+		meta.Description = "nothing"
+		return os.ErrInvalid
+	}); err != os.ErrInvalid {
+		t.Fatal("UpdateMeta should return the error of the update function, got:", err)
+	}
+	if meta, err = g.GetMeta(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if meta.Description != "grants access to the staging systems" {
+		t.Fatal("UpdateMeta must not change the meta data if the update function fails")
+	}
+}
@@ -0,0 +1,436 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// These are the defaults for the container entries of users and groups used by
+// WriteLDIF and ReadLDIF.
+const (
+	DefaultLDIFUsersRDN  = "ou=people"
+	DefaultLDIFGroupsRDN = "ou=groups"
+)
+
+// LDIFOptions contains the location of users and groups inside the directory
+// tree. Users get the DN uid=<name>,<UsersRDN>,<BaseDN> and groups the DN
+// cn=<name>,<GroupsRDN>,<BaseDN>. If UsersRDN or GroupsRDN are empty the
+// defaults are used.
+type LDIFOptions struct {
+	BaseDN    string
+	UsersRDN  string
+	GroupsRDN string
+}
+
+func joinDN(rdns ...string) string {
+	parts := make([]string, 0, len(rdns))
+	for _, rdn := range rdns {
+		if rdn != "" {
+			parts = append(parts, rdn)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (o *LDIFOptions) userDN(user string) string {
+	container := o.UsersRDN
+	if container == "" {
+		container = DefaultLDIFUsersRDN
+	}
+	return joinDN("uid="+user, container, o.BaseDN)
+}
+
+func (o *LDIFOptions) groupDN(group string) string {
+	container := o.GroupsRDN
+	if container == "" {
+		container = DefaultLDIFGroupsRDN
+	}
+	return joinDN("cn="+group, container, o.BaseDN)
+}
+
+// writeLDIFAttr writes a single attribute. Values which are not safe strings
+// according to RFC 2849 get base64 encoded.
+func writeLDIFAttr(w io.Writer, attr, value string) {
+	safe := true
+	for i, c := range value {
+		if c == 0 || c == '\n' || c == '\r' || c > 127 || (i == 0 && (c == ' ' || c == ':' || c == '<')) {
+			safe = false
+			break
+		}
+	}
+	if !safe || strings.HasSuffix(value, " ") {
+		fmt.Fprintf(w, "%s:: %s\n", attr, base64.StdEncoding.EncodeToString([]byte(value)))
+		return
+	}
+	fmt.Fprintf(w, "%s: %s\n", attr, value)
+}
+
+// WriteLDIF writes all users as inetOrgPerson and all groups as groupOfNames
+// entries to w. The member attribute of a group contains the DNs of its direct
+// user and group members. Since groupOfNames requires at least one member,
+// empty groups get a single empty member value. Users without a name get their
+// user name as cn and sn, both are required by inetOrgPerson. Implicit user
+// groups are not written.
+func (d *Dir) WriteLDIF(w io.Writer, opts LDIFOptions) error {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	users, err := d.listUsers()
+	if err != nil {
+		return err
+	}
+	groups, err := d.listGroups()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "version: 1\n")
+	for _, user := range users {
+		meta, err := NewUserFile(d, user).Get()
+		if err != nil {
+			return err
+		}
+		cn := strings.TrimSpace(meta.FirstName + " " + meta.LastName)
+		if cn == "" {
+			cn = user
+		}
+		sn := meta.LastName
+		if sn == "" {
+			sn = user
+		}
+
+		fmt.Fprintf(bw, "\n")
+		writeLDIFAttr(bw, "dn", opts.userDN(user))
+		writeLDIFAttr(bw, "objectClass", "inetOrgPerson")
+		writeLDIFAttr(bw, "uid", user)
+		writeLDIFAttr(bw, "cn", cn)
+		writeLDIFAttr(bw, "sn", sn)
+		if meta.FirstName != "" {
+			writeLDIFAttr(bw, "givenName", meta.FirstName)
+		}
+		if meta.Mail != "" {
+			writeLDIFAttr(bw, "mail", meta.Mail)
+		}
+	}
+	for _, group := range groups {
+		g := NewGroupDir(d, group)
+		meta, err := g.GetMeta()
+		if err != nil {
+			return err
+		}
+		users, groups, err := g.readMembers()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(bw, "\n")
+		writeLDIFAttr(bw, "dn", opts.groupDN(group))
+		writeLDIFAttr(bw, "objectClass", "groupOfNames")
+		writeLDIFAttr(bw, "cn", group)
+		if meta.Description != "" {
+			writeLDIFAttr(bw, "description", meta.Description)
+		}
+		if len(users) == 0 && len(groups) == 0 {
+			writeLDIFAttr(bw, "member", "")
+		}
+		for _, user := range users {
+			writeLDIFAttr(bw, "member", opts.userDN(user))
+		}
+		for _, member := range groups {
+			writeLDIFAttr(bw, "member", opts.groupDN(member))
+		}
+	}
+	return bw.Flush()
+}
+
+// ldifEntry is a single entry of an LDIF file. The attribute names are stored
+// in lower case.
+type ldifEntry struct {
+	line  int
+	dn    string
+	attrs map[string][]string
+}
+
+func (e *ldifEntry) first(attr string) string {
+	if values := e.attrs[attr]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (e *ldifEntry) hasObjectClass(class string) bool {
+	for _, c := range e.attrs["objectclass"] {
+		if strings.EqualFold(c, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// rdnValue returns the value of the first RDN of the entry if its attribute
+// type is attr.
+func (e *ldifEntry) rdnValue(attr string) string {
+	rdn := splitDN(e.dn)[0]
+	if i := strings.Index(rdn, "="); i >= 0 && strings.EqualFold(strings.TrimSpace(rdn[:i]), attr) {
+		return strings.TrimSpace(rdn[i+1:])
+	}
+	return ""
+}
+
+// splitDN splits dn into its RDNs. Escaped commas are not treated as
+// separators.
+func splitDN(dn string) []string {
+	var rdns []string
+	start := 0
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			rdns = append(rdns, strings.TrimSpace(dn[start:i]))
+			start = i + 1
+		}
+	}
+	return append(rdns, strings.TrimSpace(dn[start:]))
+}
+
+// normalizeDN converts dn to a form suitable for comparisons. LDAP compares the
+// attributes used in DNs case-insensitively.
+func normalizeDN(dn string) string {
+	rdns := splitDN(dn)
+	for i, rdn := range rdns {
+		if j := strings.Index(rdn, "="); j >= 0 {
+			rdn = strings.TrimSpace(rdn[:j]) + "=" + strings.TrimSpace(rdn[j+1:])
+		}
+		rdns[i] = strings.ToLower(rdn)
+	}
+	return strings.Join(rdns, ",")
+}
+
+// ldifLine is a logical line of an LDIF file with continuation lines unfolded.
+// Empty lines separate entries.
+type ldifLine struct {
+	lineno int
+	text   string
+}
+
+// readLDIFLines reads all logical lines of an LDIF file skipping comments.
+func readLDIFLines(r io.Reader) ([]ldifLine, error) {
+	var lines []ldifLine
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(text, "#"):
+		case strings.HasPrefix(text, " "):
+			if len(lines) == 0 || lines[len(lines)-1].text == "" {
+				return nil, fmt.Errorf("whawty.groups.store: LDIF line %d: unexpected continuation line", lineno)
+			}
+			lines[len(lines)-1].text += text[1:]
+		default:
+			lines = append(lines, ldifLine{lineno, text})
+		}
+	}
+	return append(lines, ldifLine{}), scanner.Err()
+}
+
+// parseLDIFAttr splits a logical line into the lower case attribute name and
+// the decoded value.
+func parseLDIFAttr(line ldifLine) (attr, value string, err error) {
+	i := strings.Index(line.text, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("whawty.groups.store: LDIF line %d: invalid attribute '%s'", line.lineno, line.text)
+	}
+	attr, value = strings.ToLower(line.text[:i]), line.text[i+1:]
+	switch {
+	case strings.HasPrefix(value, ":"):
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+		if err != nil {
+			return "", "", fmt.Errorf("whawty.groups.store: LDIF line %d: invalid base64 value of '%s': %v", line.lineno, attr, err)
+		}
+		value = string(data)
+	case strings.HasPrefix(value, "<"):
+		return "", "", fmt.Errorf("whawty.groups.store: LDIF line %d: URL values are not supported", line.lineno)
+	default:
+		value = strings.TrimLeft(value, " ")
+	}
+	return attr, value, nil
+}
+
+// readLDIFEntries parses the content records of an LDIF file.
+func readLDIFEntries(r io.Reader) ([]*ldifEntry, error) {
+	lines, err := readLDIFLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*ldifEntry
+	var entry *ldifEntry
+	for i, line := range lines {
+		if line.text == "" {
+			if entry != nil {
+				entries = append(entries, entry)
+				entry = nil
+			}
+			continue
+		}
+		attr, value, err := parseLDIFAttr(line)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case i == 0 && attr == "version":
+			if value != "1" {
+				return nil, fmt.Errorf("whawty.groups.store: LDIF version %s is not supported", value)
+			}
+		case entry == nil && attr == "dn":
+			entry = &ldifEntry{line: line.lineno, dn: value, attrs: make(map[string][]string)}
+		case entry == nil:
+			return nil, fmt.Errorf("whawty.groups.store: LDIF line %d: expected dn, got '%s'", line.lineno, attr)
+		case attr == "changetype":
+			if value != "add" {
+				return nil, fmt.Errorf("whawty.groups.store: LDIF line %d: changetype '%s' is not supported", line.lineno, value)
+			}
+		default:
+			entry.attrs[attr] = append(entry.attrs[attr], value)
+		}
+	}
+	return entries, nil
+}
+
+// ReadLDIF reads inetOrgPerson and groupOfNames entries from r and adds them as
+// users and groups to the store. Entries of other object classes are ignored.
+// Members of groups get added as user or group members depending on the entry
+// their DN refers to. Member DNs which don't refer to an entry of r are matched
+// against the DNs of users and groups defined by opts so groups can refer to
+// users and groups already in the store. All changes are made in a single
+// transaction, so if any of them fails nothing is changed.
+func (d *Dir) ReadLDIF(r io.Reader, opts LDIFOptions) error {
+	entries, err := readLDIFEntries(r)
+	if err != nil {
+		return err
+	}
+
+	userDNs := make(map[string]string)
+	groupDNs := make(map[string]string)
+	var users, groups []*ldifEntry
+	for _, e := range entries {
+		switch {
+		case e.hasObjectClass("inetOrgPerson"):
+			name := e.rdnValue("uid")
+			if name == "" {
+				name = e.first("uid")
+			}
+			if name == "" {
+				return fmt.Errorf("whawty.groups.store: LDIF line %d: user '%s' has no uid", e.line, e.dn)
+			}
+			userDNs[normalizeDN(e.dn)] = name
+			users = append(users, e)
+		case e.hasObjectClass("groupOfNames"):
+			name := e.rdnValue("cn")
+			if name == "" {
+				name = e.first("cn")
+			}
+			if name == "" {
+				return fmt.Errorf("whawty.groups.store: LDIF line %d: group '%s' has no cn", e.line, e.dn)
+			}
+			groupDNs[normalizeDN(e.dn)] = name
+			groups = append(groups, e)
+		}
+	}
+
+	tx := d.Begin()
+	for _, e := range users {
+		name := userDNs[normalizeDN(e.dn)]
+		meta := &UserMeta{FirstName: e.first("givenname"), Mail: e.first("mail")}
+		// sn is only the placeholder written by WriteLDIF for users without a name
+		// if cn is the user name as well and there is no givenName
+		if sn := e.first("sn"); sn != name || e.first("cn") != name || meta.FirstName != "" {
+			meta.LastName = sn
+		}
+		tx.AddUser(name)
+		tx.SetUserMeta(name, meta)
+	}
+	for _, e := range groups {
+		name := groupDNs[normalizeDN(e.dn)]
+		tx.AddGroup(name)
+		tx.SetGroupMeta(name, &GroupMeta{Description: e.first("description")})
+	}
+	for _, e := range groups {
+		name := groupDNs[normalizeDN(e.dn)]
+		members := e.attrs["member"]
+		sort.Strings(members)
+		for _, member := range members {
+			if strings.TrimSpace(member) == "" {
+				continue
+			}
+			dn := normalizeDN(member)
+			if user, ok := userDNs[dn]; ok {
+				tx.AddUserMember(name, user)
+			} else if group, ok := groupDNs[dn]; ok {
+				tx.AddGroupMember(name, group)
+			} else if user, ok := matchDN(member, "uid", opts.userDN); ok {
+				tx.AddUserMember(name, user)
+			} else if group, ok := matchDN(member, "cn", opts.groupDN); ok {
+				tx.AddGroupMember(name, group)
+			} else {
+				tx.Rollback()
+				return fmt.Errorf("whawty.groups.store: LDIF line %d: unknown member '%s' of group '%s'", e.line, member, name)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// matchDN checks whether dn has the form generated by dnOf and returns the name
+// it contains.
+func matchDN(dn, attr string, dnOf func(string) string) (string, bool) {
+	rdn := splitDN(dn)[0]
+	i := strings.Index(rdn, "=")
+	if i < 0 || !strings.EqualFold(strings.TrimSpace(rdn[:i]), attr) {
+		return "", false
+	}
+	name := strings.TrimSpace(rdn[i+1:])
+	if !nameRe.MatchString(name) || normalizeDN(dnOf(name)) != normalizeDN(dn) {
+		return "", false
+	}
+	return name, true
+}
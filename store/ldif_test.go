@@ -0,0 +1,234 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteLDIF(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	tx := store.Begin()
+	tx.AddUser("hugo")
+	tx.SetUserMeta("hugo", &UserMeta{FirstName: "Hugo", LastName: "Hüber", Mail: "hugo@example.com"})
+	tx.AddUser("fredl")
+	tx.AddUser("alice")
+	tx.SetUserMeta("alice", &UserMeta{FirstName: "Alice", LastName: "alice"})
+	tx.AddGroup("admins")
+	tx.SetGroupMeta("admins", &GroupMeta{Description: "grants root access"})
+	tx.AddGroup("devs")
+	tx.AddGroup("empty")
+	tx.AddUserMember("admins", "hugo")
+	tx.AddGroupMember("admins", "devs")
+	tx.AddUserMember("devs", "fredl")
+	if err := tx.Commit(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var buf bytes.Buffer
+	if err := store.WriteLDIF(&buf, LDIFOptions{BaseDN: "dc=example,dc=com"}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := `version: 1
+
+dn: uid=alice,ou=people,dc=example,dc=com
+objectClass: inetOrgPerson
+uid: alice
+cn: Alice alice
+sn: alice
+givenName: Alice
+
+dn: uid=fredl,ou=people,dc=example,dc=com
+objectClass: inetOrgPerson
+uid: fredl
+cn: fredl
+sn: fredl
+
+dn: uid=hugo,ou=people,dc=example,dc=com
+objectClass: inetOrgPerson
+uid: hugo
+cn:: SHVnbyBIw7xiZXI=
+sn:: SMO8YmVy
+givenName: Hugo
+mail: hugo@example.com
+
+dn: cn=admins,ou=groups,dc=example,dc=com
+objectClass: groupOfNames
+cn: admins
+description: grants root access
+member: uid=hugo,ou=people,dc=example,dc=com
+member: cn=devs,ou=groups,dc=example,dc=com
+
+dn: cn=devs,ou=groups,dc=example,dc=com
+objectClass: groupOfNames
+cn: devs
+member: uid=fredl,ou=people,dc=example,dc=com
+
+dn: cn=empty,ou=groups,dc=example,dc=com
+objectClass: groupOfNames
+cn: empty
+member: 
+`
+	if buf.String() != expected {
+		t.Fatalf("WriteLDIF wrote:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	if err := os.RemoveAll(testBaseDir); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.ReadLDIF(&buf, LDIFOptions{}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if meta, err := store.GetUserMeta("hugo"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if meta.FirstName != "Hugo" || meta.LastName != "Hüber" || meta.Mail != "hugo@example.com" {
+		t.Fatalf("wrong meta data for 'hugo': %+v", meta)
+	}
+	if meta, err := store.GetUserMeta("fredl"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if meta.LastName != "" {
+		t.Fatalf("wrong meta data for 'fredl': %+v", meta)
+	}
+	if meta, err := store.GetUserMeta("alice"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if meta.FirstName != "Alice" || meta.LastName != "alice" {
+		t.Fatalf("wrong meta data for 'alice': %+v", meta)
+	}
+	if users, groups, err := store.ListMembers("admins"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if !reflect.DeepEqual(users, []string{"hugo"}) || !reflect.DeepEqual(groups, []string{"devs"}) {
+		t.Fatalf("wrong members of 'admins': %v %v", users, groups)
+	}
+	if users, groups, err := store.ListMembers("empty"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if len(users) != 0 || len(groups) != 0 {
+		t.Fatalf("wrong members of 'empty': %v %v", users, groups)
+	}
+}
+
+func TestReadLDIF(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUser("Existing"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	ldif := `# some comment
+dn: ou=staff,o=example
+objectClass: organizationalUnit
+ou: staff
+
+dn: uid=hugo, ou=staff, o=example
+objectClass: top
+objectClass: inetOrgPerson
+cn: Hugo
+sn: Huber
+description: this is a long line which is fold
+ ed
+mail: hugo@example.com
+
+dn: CN=Admins,OU=Teams,O=Example
+objectclass: groupOfNames
+cn: Admins
+member: uid=Hugo,ou=staff,o=example
+member: cn=nested,ou=teams,o=example
+member: uid=Existing,ou=people,o=example
+
+dn: cn=nested,ou=teams,o=example
+changetype: add
+objectClass: GROUPOFNAMES
+member:: dWlkPWh1Z28sb3U9c3RhZmYsbz1leGFtcGxl
+`
+	if err := store.ReadLDIF(strings.NewReader(ldif), LDIFOptions{BaseDN: "o=example"}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if users, err := store.ListUsers(""); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"Existing", "hugo"}; !reflect.DeepEqual(users, expected) {
+		t.Fatalf("wrong users, expected %v, got %v", expected, users)
+	}
+	if members, err := store.EffectiveMembers("Admins"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"Existing", "hugo"}; !reflect.DeepEqual(members, expected) {
+		t.Fatalf("wrong members of 'Admins', expected %v, got %v", expected, members)
+	}
+	if _, groups, err := store.ListMembers("Admins"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"nested"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("wrong group members of 'Admins', expected %v, got %v", expected, groups)
+	}
+
+	invalid := []string{
+		"version: 2\n",
+		"cn: foo\n",
+		"dn: cn=foo,o=example\nobjectClass: groupOfNames\nmember: uid=unknown,ou=elsewhere\n",
+		"dn: cn=foo,o=example\nchangetype: delete\n",
+		"dn: cn=foo,o=example\nobjectClass: groupOfNames\nmember: cn=foo,o=example\n",
+		"dn: cn=bar,o=example\nobjectClass: groupOfNames\n\ndn: cn=bar,o=example\nobjectClass: groupOfNames\n",
+	}
+	for _, ldif := range invalid {
+		if err := store.ReadLDIF(strings.NewReader(ldif), LDIFOptions{}); err == nil {
+			t.Fatalf("reading '%s' should throw an error", ldif)
+		}
+	}
+	if groups, err := store.ListGroups(""); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"Admins", "nested"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("failed reads must not change the store, expected groups %v, got %v", expected, groups)
+	}
+}
@@ -0,0 +1,106 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	lockFile string = ".lock"
+
+	// DefaultLockTimeout is used if Options.LockTimeout is zero.
+	DefaultLockTimeout = 10 * time.Second
+
+	lockRetryInterval = 10 * time.Millisecond
+)
+
+var (
+	// ErrLockTimeout is returned if the store lock could not be acquired within
+	// the configured timeout.
+	ErrLockTimeout = errors.New("whawty.groups.store: timeout while waiting for the store lock")
+)
+
+// openLockFile opens, and if needed creates, the lock file of the store. If the
+// file can't be opened for writing it falls back to read-only mode which is
+// sufficient for advisory locks.
+func (d *Dir) openLockFile() (*os.File, error) {
+	filename := filepath.Join(d.basedir, lockFile)
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err == nil || !os.IsPermission(err) {
+		return file, err
+	}
+	return os.Open(filename)
+}
+
+// lock acquires an advisory lock on the store which is shared by all processes
+// using the same base directory. All methods which modify the store must hold
+// an exclusive lock, methods which only read the store use a shared lock. The
+// returned function releases the lock. If the lock can't be acquired within the
+// configured timeout ErrLockTimeout is returned.
+func (d *Dir) lock(exclusive bool) (unlock func(), err error) {
+	var file *os.File
+	if file, err = d.openLockFile(); err != nil {
+		return
+	}
+
+	timeout := d.opts.LockTimeout
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		var locked bool
+		if locked, err = tryLockFile(file, exclusive); err != nil {
+			file.Close()
+			return
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, ErrLockTimeout
+		}
+		time.Sleep(lockRetryInterval)
+	}
+
+	unlock = func() {
+		if err := unlockFile(file); err != nil {
+			wl.Printf("Warning: releasing the store lock failed: %v", err)
+		}
+		file.Close()
+	}
+	return
+}
@@ -0,0 +1,106 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	store := NewDirWithOptions(testBaseDir, Options{LockTimeout: 50 * time.Millisecond})
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, err := os.Stat(filepath.Join(testBaseDir, lockFile)); err != nil {
+		t.Fatal("Init should create the lock file:", err)
+	}
+	if err := store.AddGroup("staff"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// another store instance behaves like another process
+	other := NewDirWithOptions(testBaseDir, Options{LockTimeout: -1})
+
+	unlock, err := other.lock(false)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, err := store.ListGroups(""); err != nil {
+		t.Fatal("readers should not block each other:", err)
+	}
+	if err := store.Check(); err != nil {
+		t.Fatal("check should succeed while the store is locked:", err)
+	}
+	start := time.Now()
+	if err := store.AddUser("hugo"); err != ErrLockTimeout {
+		t.Fatal("writing while a shared lock is held should time out, got:", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("acquiring the lock should have been retried until the timeout, gave up after %v", elapsed)
+	}
+	unlock()
+
+	if unlock, err = other.lock(true); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, err := store.ListGroups(""); err != ErrLockTimeout {
+		t.Fatal("reading while an exclusive lock is held should time out, got:", err)
+	}
+	if err := store.AddUserMember("staff", "hugo"); err != ErrLockTimeout {
+		t.Fatal("writing while an exclusive lock is held should time out, got:", err)
+	}
+
+	released := make(chan bool)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		unlock()
+		close(released)
+	}()
+	if err := store.AddUser("hugo"); err != nil {
+		t.Fatal("writing should succeed once the lock gets released:", err)
+	}
+	<-released
+
+	if users, err := other.ListUsers(""); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if len(users) != 1 {
+		t.Fatalf("store should contain one user, got: %v", users)
+	}
+}
@@ -0,0 +1,173 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Fix describes a single fix for a problem found by Check.
+type Fix struct {
+	Finding Finding
+	Action  string
+}
+
+func (f Fix) String() string {
+	return fmt.Sprintf("%s: %s", f.Finding.Path, f.Action)
+}
+
+// Repair fixes all problems found by Check which can be fixed safely. These
+// are dangling membership links, which get removed, missing group meta data
+// files, which get recreated, stale files in the temporary directory, which
+// get deleted, and absolute membership links, which get replaced by relative
+// links. All other problems need to be fixed manually. Repair returns the list
+// of fixes applied. If dryRun is set nothing is changed and the returned list
+// contains the fixes which would have been applied. Unless dryRun is set the
+// applied fixes are recorded in the audit log and committed in git mode, even
+// if an error occurred.
+func (d *Dir) Repair(dryRun bool) (fixes []Fix, err error) {
+	unlock, err := d.lock(!dryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	err = d.check()
+	if err == nil {
+		return
+	}
+	cerr, ok := err.(*CheckError)
+	if !ok {
+		return
+	}
+	err = nil
+	if !dryRun {
+		defer func() {
+			if len(fixes) == 0 {
+				return
+			}
+			actions := make([]string, 0, len(fixes))
+			for _, f := range fixes {
+				actions = append(actions, f.String())
+			}
+			record := d.newAuditRecord(AuditRepair, "", "", nil, actions)
+			aerr := d.audit(record)
+			if aerr == nil {
+				aerr = d.gitCommit([]*AuditRecord{record})
+			}
+			if err == nil {
+				err = aerr
+			}
+		}()
+	}
+
+	removed := make(map[string]bool)
+	for _, f := range cerr.Findings {
+		var action string
+		var fix func() error
+		path := filepath.Join(d.basedir, f.Path)
+		switch f.Kind {
+		case FindingDanglingLink:
+			action = "remove dangling link"
+			fix = func() error { return os.Remove(path) }
+			removed[f.Path] = true
+		case FindingStaleTempFile:
+			action = "remove stale temporary file"
+			fix = func() error { return os.RemoveAll(path) }
+		case FindingMissingMetaFile:
+			action = fmt.Sprintf("create %s", groupMetaFile)
+			fix = NewGroupDir(d, filepath.Base(f.Path)).addMetaFile
+		default:
+			continue
+		}
+		fixes = append(fixes, Fix{f, action})
+		if !dryRun {
+			if err = fix(); err != nil {
+				return
+			}
+		}
+	}
+
+	for _, f := range cerr.Findings {
+		if f.Kind != FindingAbsoluteLink || removed[f.Path] {
+			continue
+		}
+		var target string
+		if target, err = relativeLinkTarget(filepath.Join(d.basedir, f.Path)); err != nil {
+			return
+		}
+		fixes = append(fixes, Fix{f, fmt.Sprintf("replace with relative link to '%s'", target)})
+		if !dryRun {
+			if err = d.replaceLink(filepath.Join(d.basedir, f.Path), target); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// relativeLinkTarget returns the target of the absolute symlink path as a path
+// relative to the directory containing the link.
+func relativeLinkTarget(path string) (string, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(dir, target)
+}
+
+// replaceLink atomically replaces the symlink path with a new link to target.
+func (d *Dir) replaceLink(path, target string) error {
+	file, err := d.getTempFile()
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	file.Close()
+	if err := os.Remove(tmp); err != nil {
+		return err
+	}
+
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,172 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRepair(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if fixes, err := store.Repair(false); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if len(fixes) != 0 {
+		t.Fatalf("repairing a consistent store should do nothing: %v", fixes)
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		if err := store.AddUser(user); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, group := range []string{"staff", "devs"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if err := store.AddUserMember("staff", "bob"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUserMember("devs", "bob"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// dangling link
+	if err := os.Remove(filepath.Join(testBaseDir, usersDir, "bob")); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	// missing meta file
+	if err := os.Remove(filepath.Join(testBaseDir, groupsDir, "devs", groupMetaFile)); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	// absolute link
+	abs, err := filepath.Abs(filepath.Join(testBaseDir, usersDir, "alice"))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	absLink := filepath.Join(testBaseDir, groupsDir, "staff", "alice")
+	if err := os.Symlink(abs, absLink); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	// stale and fresh temporary files
+	if err := os.MkdirAll(filepath.Join(testBaseDir, tmpDir), 0700); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	stale := filepath.Join(testBaseDir, tmpDir, "stale")
+	fresh := filepath.Join(testBaseDir, tmpDir, "fresh")
+	for _, name := range []string{stale, fresh} {
+		if err := ioutil.WriteFile(name, []byte("changed: 2016-01-01T00:00:00Z\n"), 0600); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	old := time.Now().Add(-2 * staleTempFileAge)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	// not fixable
+	if err := ioutil.WriteFile(filepath.Join(testBaseDir, groupsDir, "staff", "file"), nil, 0644); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	expected := []struct {
+		kind FindingKind
+		path string
+	}{
+		{FindingDanglingLink, filepath.Join(groupsDir, "staff", "bob")},
+		{FindingDanglingLink, filepath.Join(groupsDir, "devs", "bob")},
+		{FindingMissingMetaFile, filepath.Join(groupsDir, "devs")},
+		{FindingAbsoluteLink, filepath.Join(groupsDir, "staff", "alice")},
+		{FindingStaleTempFile, filepath.Join(tmpDir, "stale")},
+	}
+
+	fixes, err := store.Repair(true)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(fixes) != len(expected) {
+		t.Fatalf("dry-run should list %d fixes, got: %v", len(expected), fixes)
+	}
+	findings := make([]Finding, 0, len(fixes))
+	for _, f := range fixes {
+		findings = append(findings, f.Finding)
+	}
+	for _, e := range expected {
+		if !hasFinding(findings, e.kind, e.path) {
+			t.Errorf("dry-run didn't list a fix for '%s' at %s", e.kind, e.path)
+		}
+	}
+	if cerr, ok := store.Check().(*CheckError); !ok || len(cerr.Findings) != len(expected)+1 {
+		t.Fatalf("dry-run must not change anything: %v", cerr)
+	}
+
+	if fixes, err := store.Repair(false); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if len(fixes) != len(expected) {
+		t.Fatalf("repair should apply %d fixes, got: %v", len(expected), fixes)
+	}
+
+	err = store.Check()
+	cerr, ok := err.(*CheckError)
+	if !ok {
+		t.Fatalf("check should still report the unfixable problem, got: %v", err)
+	}
+	if len(cerr.Findings) != 1 || !hasFinding(cerr.Findings, FindingNotASymlink, filepath.Join(groupsDir, "staff", "file")) {
+		t.Fatalf("only the unfixable problem should be left: %v", cerr)
+	}
+
+	if target, err := os.Readlink(absLink); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if target != filepath.Join("..", "..", usersDir, "alice") {
+		t.Fatalf("absolute link should have been replaced by a relative one, got: %s", target)
+	}
+	if isMember, err := store.IsMember("staff", "alice"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if !isMember {
+		t.Fatal("the repaired link should still make alice a member of staff")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Fatal("fresh temporary files must not be removed:", err)
+	}
+}
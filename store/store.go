@@ -42,6 +42,9 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 )
 
 var (
@@ -64,15 +67,165 @@ func init() {
 	}
 }
 
-// Dir represents a directory containing a whawty.groups store. Use NewDir to create it.
+// LoopError is returned when adding a group member would create a membership
+// loop. Path contains the names of the groups which form the loop, the first and
+// the last element are the same group.
+type LoopError struct {
+	Path []string
+}
+
+func (e *LoopError) Error() string {
+	return fmt.Sprintf("whawty.groups.store: membership loop detected: %s", strings.Join(e.Path, " -> "))
+}
+
+// SelfMemberError is returned when adding a group as a member of itself.
+type SelfMemberError struct {
+	Group string
+}
+
+func (e *SelfMemberError) Error() string {
+	return fmt.Sprintf("whawty.groups.store: group '%s' can't be a member of itself", e.Group)
+}
+
+// GIDError is returned when setting the GID of a group which has no GID yet to
+// a GID which can't be used. Group is the name of the group which already uses
+// GID. If Group is empty GID is not higher than the highest GID ever assigned
+// and might have belonged to a group which was removed, see Dir.AssignGIDs.
+type GIDError struct {
+	GID   int
+	Group string
+}
+
+func (e *GIDError) Error() string {
+	if e.Group != "" {
+		return fmt.Sprintf("whawty.groups.store: gid %d is already used by group '%s'", e.GID, e.Group)
+	}
+	return fmt.Sprintf("whawty.groups.store: gid %d is not higher than the highest gid ever assigned", e.GID)
+}
+
+// NotExistError is returned when a user or group does not exist. Kind is either
+// "user" or "group".
+type NotExistError struct {
+	Kind string
+	Name string
+}
+
+func (e *NotExistError) Error() string {
+	return fmt.Sprintf("whawty.groups.store: %s '%s' does not exist", e.Kind, e.Name)
+}
+
+// ExistError is returned when a user or group can't be created because its name
+// is already in use. Kind is the kind of the new entry, "user", "group" or empty
+// if it can be either. UsedBy is the kind of the existing entry.
+type ExistError struct {
+	Kind   string
+	Name   string
+	UsedBy string
+}
+
+func (e *ExistError) Error() string {
+	switch e.Kind {
+	case e.UsedBy:
+		return fmt.Sprintf("whawty.groups.store: %s '%s' already exists", e.Kind, e.Name)
+	case "":
+		return fmt.Sprintf("whawty.groups.store: name '%s' is already used by a %s", e.Name, e.UsedBy)
+	}
+	return fmt.Sprintf("whawty.groups.store: %s name '%s' is already used by a %s", e.Kind, e.Name, e.UsedBy)
+}
+
+// InvalidNameError is returned when a name doesn't match the rules for user and
+// group names. Kind is "user", "group" or empty if the name can be either.
+type InvalidNameError struct {
+	Kind string
+	Name string
+}
+
+func (e *InvalidNameError) Error() string {
+	if e.Kind == "" {
+		return fmt.Sprintf("name '%s' is invalid", e.Name)
+	}
+	return fmt.Sprintf("%s name '%s' is invalid", e.Kind, e.Name)
+}
+
+// NotEmptyError is returned when removing a group which still has members
+// without force.
+type NotEmptyError struct {
+	Group string
+}
+
+func (e *NotEmptyError) Error() string {
+	return fmt.Sprintf("whawty.groups.store: group '%s' is not empty", e.Group)
+}
+
+// cause returns the error which caused err. Errors of changes which were rolled
+// back are unwrapped.
+func cause(err error) error {
+	if rerr, ok := err.(*RollbackError); ok {
+		return rerr.Err
+	}
+	return err
+}
+
+// IsNotExist returns whether err reports that a user or group does not exist.
+// It is also true for errors for which os.IsNotExist is true.
+func IsNotExist(err error) bool {
+	err = cause(err)
+	if _, ok := err.(*NotExistError); ok {
+		return true
+	}
+	return os.IsNotExist(err)
+}
+
+// IsExist returns whether err reports that a user or group already exists.
+func IsExist(err error) bool {
+	_, ok := cause(err).(*ExistError)
+	return ok
+}
+
+// Options contains the configuration of a whawty.groups store.
+type Options struct {
+	// ImplicitUserGroups enables an implicit group for every user. The only
+	// member of such a group is the user with the same name. Implicit groups
+	// are never stored on disk but are generated for membership queries.
+	ImplicitUserGroups bool
+
+	// LockTimeout is the maximum time to wait for the store lock. If it is zero
+	// DefaultLockTimeout is used, if it is negative acquiring the lock is only
+	// tried once.
+	LockTimeout time.Duration
+
+	// Actor is the name recorded in the audit log for all changes made through
+	// this store. If it is empty the name of the user running the current
+	// process is used.
+	Actor string
+
+	// Git enables git mode. Init creates a git repository inside the base
+	// directory and every change gets committed to it using Actor as author.
+	Git bool
+}
+
+// Dir represents a directory containing a whawty.groups store. Use NewDir or
+// NewDirWithOptions to create it.
 type Dir struct {
 	basedir string
+	opts    Options
 }
 
-// NewDir creates a new whawty.groups store using basedir as base directory.
+// NewDir creates a new whawty.groups store using basedir as base directory
+// and the default options.
 func NewDir(basedir string) (d *Dir) {
+	return NewDirWithOptions(basedir, Options{})
+}
+
+// NewDirWithOptions creates a new whawty.groups store using basedir as base
+// directory and the options opts.
+func NewDirWithOptions(basedir string, opts Options) (d *Dir) {
 	d = &Dir{}
 	d.basedir = filepath.Clean(basedir)
+	d.opts = opts
+	if d.opts.Actor == "" {
+		d.opts.Actor = defaultActor()
+	}
 	return
 }
 
@@ -95,9 +248,13 @@ func openDir(path string) (*os.File, error) {
 	return dir, nil
 }
 
+// isDirEmpty checks whether dir contains anything else than the lock file.
 func isDirEmpty(dir *os.File) bool {
-	if _, err := dir.Readdir(1); err == nil {
-		return false
+	names, _ := dir.Readdirnames(0)
+	for _, name := range names {
+		if name != lockFile {
+			return false
+		}
 	}
 	return true
 }
@@ -127,7 +284,7 @@ func fileExists(path string) (bool, error) {
 }
 
 // getTempFile provides a new, empty file in the base's .tmp directory,
-//  suitable for atomic file updates (by create/write/rename)
+// suitable for atomic file updates (by create/write/rename)
 func (d *Dir) getTempFile() (*os.File, error) {
 	tmpDir := filepath.Join(d.basedir, tmpDir)
 	if err := os.MkdirAll(tmpDir, 0700); err != nil {
@@ -137,8 +294,71 @@ func (d *Dir) getTempFile() (*os.File, error) {
 	return ioutil.TempFile(tmpDir, "")
 }
 
-// Init initializes the store by creating directories for users and groups
+// getTempDir provides a new, empty directory in the base's .tmp directory,
+// suitable for atomic directory creation (by create/fill/rename)
+func (d *Dir) getTempDir() (string, error) {
+	tmpDir := filepath.Join(d.basedir, tmpDir)
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return "", err
+	}
+
+	return ioutil.TempDir(tmpDir, "")
+}
+
+// syncDir flushes the directory path, and therefore all changes to its entries,
+// to disk.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// writeFile atomically replaces the contents of filename with data. The data is
+// written to a temporary file which is flushed to disk and then renamed to
+// filename. Readers will therefore either see the old or the new contents but
+// never a partially written file.
+func (d *Dir) writeFile(filename string, data []byte, perm os.FileMode) (err error) {
+	var file *os.File
+	if file, err = d.getTempFile(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+
+	if _, err = file.Write(data); err == nil {
+		if err = file.Chmod(perm); err == nil {
+			err = file.Sync()
+		}
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+
+	if err = os.Rename(file.Name(), filename); err != nil {
+		return
+	}
+	return syncDir(filepath.Dir(filename))
+}
+
+// Init initializes the store by creating directories for users and groups as
+// well as the lock file. The initialization is recorded in the audit log. In git
+// mode a git repository is created as well.
 func (d *Dir) Init() error {
+	unlock, err := d.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	dir, err := openDir(d.basedir)
 	if err != nil {
 		return err
@@ -155,88 +375,298 @@ func (d *Dir) Init() error {
 	if err = os.Mkdir(filepath.Join(d.basedir, groupsDir), 0700); err != nil {
 		return err
 	}
-	return nil
+	if d.opts.Git {
+		if err = d.gitInit(); err != nil {
+			return err
+		}
+	}
+	record := d.newAuditRecord(AuditInit, "", "", nil, nil)
+	if err = d.audit(record); err != nil {
+		return err
+	}
+	return d.gitCommit([]*AuditRecord{record})
 }
 
-// Check tests if the directory is a valid whawty.group base directory.
-func (d *Dir) Check() (err error) {
-	dir, err := openDir(d.basedir)
-	if err != nil {
+// AddUser adds user to the store. It is an error if the user already exists.
+// Users and groups share a namespace so it is also an error if there is a group
+// with the same name.
+func (d *Dir) AddUser(user string) (err error) {
+	tx := d.Begin()
+	tx.AddUser(user)
+	return tx.Commit()
+}
+
+func (d *Dir) addUser(user string) (err error) {
+	if !nameRe.MatchString(user) {
+		return &InvalidNameError{"user", user}
+	}
+	if exists, err := NewGroupDir(d, user).Exists(); err != nil {
 		return err
+	} else if exists {
+		return &ExistError{"user", user, "group"}
 	}
-	defer dir.Close()
+	return NewUserFile(d, user).Add()
+}
 
-	hasUsersDir := false
-	hasGroupsDir := false
-	names, err := dir.Readdirnames(0)
-	if err != nil {
-		return err
+// RemoveUser removes user from the store. The user also gets removed from all
+// groups it is a member of, including dangling membership links. It returns
+// the names of all groups which were modified. If an error occurs all changes
+// are reverted.
+func (d *Dir) RemoveUser(user string) (groups []string, err error) {
+	tx := d.Begin()
+	tx.removeUser(user, &groups)
+	if err = tx.Commit(); err != nil {
+		return nil, err
 	}
-	for _, name := range names {
-		switch name {
-		case tmpDir:
-		case usersDir:
-			hasUsersDir = true
-			if err = isDir(filepath.Join(d.basedir, name)); err != nil {
-				return err
-			}
-		case groupsDir:
-			hasGroupsDir = true
-			if err = isDir(filepath.Join(d.basedir, name)); err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("Error: found invalid file or directory: %s", name)
-		}
+	return
+}
+
+func (d *Dir) removeUser(user string) (groups []string, err error) {
+	u := NewUserFile(d, user)
+	if err = u.checkExists(); err != nil {
+		return
 	}
 
-	if !hasGroupsDir {
-		return fmt.Errorf("Error: groups directory not found!")
+	var all []string
+	if all, err = d.listGroups(); err != nil {
+		return
 	}
-	if !hasUsersDir {
-		return fmt.Errorf("Error: users directory not found!")
+	for _, group := range all {
+		g := NewGroupDir(d, group)
+		var linked bool
+		if linked, err = g.hasUserLink(user); err != nil {
+			return
+		} else if !linked {
+			continue
+		}
+		if err = g.RemoveUserMember(user); err != nil {
+			return
+		}
+		groups = append(groups, group)
 	}
+	err = u.Remove()
+	return
+}
 
-	// TODO: check usersdir and groups dir
+// IsValidName checks whether name is a valid name for a user or group.
+func IsValidName(name string) bool {
+	return nameRe.MatchString(name)
+}
+
+// checkNewName checks whether name is a valid name for a user or group and
+// neither a user nor a group with that name exists.
+func (d *Dir) checkNewName(name string) error {
+	if !nameRe.MatchString(name) {
+		return &InvalidNameError{"", name}
+	}
+	if exists, err := NewUserFile(d, name).Exists(); err != nil {
+		return err
+	} else if exists {
+		return &ExistError{"", name, "user"}
+	}
+	if exists, err := NewGroupDir(d, name).Exists(); err != nil {
+		return err
+	} else if exists {
+		return &ExistError{"", name, "group"}
+	}
 	return nil
 }
 
-// AddUser adds user to the store. It is an error if the user already exists.
-func (d *Dir) AddUser(user string) (err error) {
-	if !nameRe.MatchString(user) {
-		return fmt.Errorf("user name '%s' is invalid", user)
+// RenameUser renames the user oldName to newName. All membership links get
+// updated accordingly. newName must be valid and must neither be used by a
+// user nor a group. It returns the names of all groups which were modified.
+// If an error occurs all changes are reverted.
+func (d *Dir) RenameUser(oldName, newName string) (groups []string, err error) {
+	tx := d.Begin()
+	tx.renameUser(oldName, newName, &groups)
+	if err = tx.Commit(); err != nil {
+		return nil, err
 	}
-	return NewUserFile(d, user).Add()
+	return
 }
 
-// RemoveUser removes user from the store.
-func (d *Dir) RemoveUser(user string) {
-	NewUserFile(d, user).Remove()
-	// TODO: remove user from all groups it is a member of
+func (d *Dir) renameUser(oldName, newName string) (groups []string, err error) {
+	u := NewUserFile(d, oldName)
+	if err = u.checkExists(); err != nil {
+		return
+	}
+	if err = d.checkNewName(newName); err != nil {
+		return
+	}
+
+	var all []string
+	if all, err = d.listGroups(); err != nil {
+		return
+	}
+	for _, group := range all {
+		var linked bool
+		if linked, err = NewGroupDir(d, group).hasUserLink(oldName); err != nil {
+			return
+		} else if linked {
+			groups = append(groups, group)
+		}
+	}
+
+	// the new links are dangling until the user file gets renamed and the old
+	// ones are dangling afterwards, this way the membership never gets lost
+	for _, group := range groups {
+		if err = NewGroupDir(d, group).AddUserMember(newName); err != nil {
+			return
+		}
+	}
+	if err = os.Rename(u.getFilename(), NewUserFile(d, newName).getFilename()); err != nil {
+		return
+	}
+	if err = syncDir(filepath.Join(d.basedir, usersDir)); err != nil {
+		return
+	}
+	for _, group := range groups {
+		if err = NewGroupDir(d, group).RemoveUserMember(oldName); err != nil {
+			return
+		}
+	}
+	return
 }
 
 // AddGroup adds group to the store. It is an error if the group already exists.
+// Users and groups share a namespace so it is also an error if there is a user
+// with the same name. This also makes sure groups never shadow implicit user
+// groups.
 func (d *Dir) AddGroup(group string) (err error) {
+	tx := d.Begin()
+	tx.AddGroup(group)
+	return tx.Commit()
+}
+
+func (d *Dir) addGroup(group string) (err error) {
 	if !nameRe.MatchString(group) {
-		return fmt.Errorf("group name '%s' is invalid", group)
+		return &InvalidNameError{"group", group}
+	}
+	if exists, err := NewUserFile(d, group).Exists(); err != nil {
+		return err
+	} else if exists {
+		return &ExistError{"group", group, "user"}
 	}
 	return NewGroupDir(d, group).Add()
 }
 
-// RemoveGroup removes group from the store.
-func (d *Dir) RemoveGroup(group string) {
-	NewGroupDir(d, group).Remove()
-	// TODO: remove group from all groups it is a member of
+// RemoveGroup removes group from the store. The group also gets removed from
+// all groups it is a member of, including dangling membership links. Unless
+// force is set it is an error if the group still has any members. It returns
+// the names of all parent groups which were modified. If an error occurs all
+// changes are reverted.
+func (d *Dir) RemoveGroup(group string, force bool) (groups []string, err error) {
+	tx := d.Begin()
+	tx.removeGroup(group, force, &groups)
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (d *Dir) removeGroup(group string, force bool) (groups []string, err error) {
+	g := NewGroupDir(d, group)
+	if err = g.checkExists(); err != nil {
+		return
+	}
+	if !force {
+		var empty bool
+		if empty, err = g.isEmpty(); err != nil {
+			return
+		} else if !empty {
+			return nil, &NotEmptyError{group}
+		}
+	}
+
+	var all []string
+	if all, err = d.listGroups(); err != nil {
+		return
+	}
+	for _, parent := range all {
+		if parent == group {
+			continue
+		}
+		p := NewGroupDir(d, parent)
+		var linked bool
+		if linked, err = p.hasGroupLink(group); err != nil {
+			return
+		} else if !linked {
+			continue
+		}
+		if err = p.RemoveGroupMember(group); err != nil {
+			return
+		}
+		groups = append(groups, parent)
+	}
+	err = g.Remove()
+	return
+}
+
+// RenameGroup renames the group oldName to newName. All links in parent groups
+// get updated accordingly. newName must be valid and must neither be used by a
+// user nor a group. It returns the names of all parent groups which were
+// modified. If an error occurs all changes are reverted.
+func (d *Dir) RenameGroup(oldName, newName string) (groups []string, err error) {
+	tx := d.Begin()
+	tx.renameGroup(oldName, newName, &groups)
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (d *Dir) renameGroup(oldName, newName string) (groups []string, err error) {
+	g := NewGroupDir(d, oldName)
+	if err = g.checkExists(); err != nil {
+		return
+	}
+	if err = d.checkNewName(newName); err != nil {
+		return
+	}
+
+	var all []string
+	if all, err = d.listGroups(); err != nil {
+		return
+	}
+	for _, parent := range all {
+		var linked bool
+		if linked, err = NewGroupDir(d, parent).hasGroupLink(oldName); err != nil {
+			return
+		} else if linked {
+			groups = append(groups, parent)
+		}
+	}
+
+	for _, parent := range groups {
+		if err = NewGroupDir(d, parent).AddGroupMember(newName); err != nil {
+			return
+		}
+	}
+	if err = os.Rename(g.getDirname(), NewGroupDir(d, newName).getDirname()); err != nil {
+		return
+	}
+	if err = syncDir(filepath.Join(d.basedir, groupsDir)); err != nil {
+		return
+	}
+	for _, parent := range groups {
+		if err = NewGroupDir(d, parent).RemoveGroupMember(oldName); err != nil {
+			return
+		}
+	}
+	return
 }
 
 // AddUserMember adds user to group. It is *not* an error if user is already
 // a member.
 func (d *Dir) AddUserMember(group, user string) error {
-	u := NewUserFile(d, user)
-	if exists, err := u.Exists(); err != nil {
+	tx := d.Begin()
+	tx.AddUserMember(group, user)
+	return tx.Commit()
+}
+
+func (d *Dir) addUserMember(group, user string) error {
+	if err := NewUserFile(d, user).checkExists(); err != nil {
 		return err
-	} else if !exists {
-		return fmt.Errorf("whawty.groups.store: user '%s' does not exist", user)
 	}
 
 	return NewGroupDir(d, group).AddUserMember(user)
@@ -245,24 +675,536 @@ func (d *Dir) AddUserMember(group, user string) error {
 // RemoveUserMember removes user from group. It is *not* an error if user
 // is not a member.
 func (d *Dir) RemoveUserMember(group, user string) error {
+	tx := d.Begin()
+	tx.RemoveUserMember(group, user)
+	return tx.Commit()
+}
+
+func (d *Dir) removeUserMember(group, user string) error {
 	return NewGroupDir(d, group).RemoveUserMember(user)
 }
 
 // AddGroupMember adds groupToAdd to group. It is *not* an error if groupToAdd
-// is already a member.
+// is already a member. A group can't be a member of itself.
 func (d *Dir) AddGroupMember(group, groupToAdd string) error {
-	g := NewGroupDir(d, groupToAdd)
-	if exists, err := g.Exists(); err != nil {
+	tx := d.Begin()
+	tx.AddGroupMember(group, groupToAdd)
+	return tx.Commit()
+}
+
+func (d *Dir) addGroupMember(group, groupToAdd string) error {
+	if group == groupToAdd {
+		return &SelfMemberError{group}
+	}
+	for _, name := range []string{group, groupToAdd} {
+		g := NewGroupDir(d, name)
+		if exists, err := g.Exists(); err != nil {
+			return err
+		} else if !exists {
+			return &NotExistError{"group", name}
+		}
+	}
+	if path, err := d.findGroupPath(groupToAdd, group); err != nil {
 		return err
-	} else if !exists {
-		return fmt.Errorf("whawty.groups.store: group '%s' does not exist", groupToAdd)
+	} else if path != nil {
+		return &LoopError{append([]string{group}, path...)}
 	}
-	// TODO: check for loops
 	return NewGroupDir(d, group).AddGroupMember(groupToAdd)
 }
 
+// findGroupPath searches for a chain of nested group memberships leading from
+// group from to group to. It returns the names of all groups along the way
+// including from and to or nil if to can't be reached. Existing loops are
+// tolerated.
+func (d *Dir) findGroupPath(from, to string) ([]string, error) {
+	visited := make(map[string]bool)
+	var walk func(group string) ([]string, error)
+	walk = func(group string) ([]string, error) {
+		if group == to {
+			return []string{group}, nil
+		}
+		if visited[group] {
+			return nil, nil
+		}
+		visited[group] = true
+
+		_, groups, err := NewGroupDir(d, group).readMembers()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, member := range groups {
+			path, err := walk(member)
+			if err != nil {
+				return nil, err
+			}
+			if path != nil {
+				return append([]string{group}, path...), nil
+			}
+		}
+		return nil, nil
+	}
+	return walk(from)
+}
+
 // RemoveGroupMember removes groupToRemove from group. It is *not* an error
 // if groupToRemove is not a member.
 func (d *Dir) RemoveGroupMember(group, groupToRemove string) error {
+	tx := d.Begin()
+	tx.RemoveGroupMember(group, groupToRemove)
+	return tx.Commit()
+}
+
+func (d *Dir) removeGroupMember(group, groupToRemove string) error {
 	return NewGroupDir(d, group).RemoveGroupMember(groupToRemove)
 }
+
+// GetUserMeta returns the meta data of user.
+func (d *Dir) GetUserMeta(user string) (*UserMeta, error) {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return NewUserFile(d, user).Get()
+}
+
+// SetUserMeta replaces the meta data of user with meta.
+func (d *Dir) SetUserMeta(user string, meta *UserMeta) error {
+	tx := d.Begin()
+	tx.SetUserMeta(user, meta)
+	return tx.Commit()
+}
+
+// UpdateUserMeta calls fn to modify the meta data of user. If fn returns an
+// error the meta data is not changed. fn gets called while the store is locked
+// and must not call methods of the store.
+func (d *Dir) UpdateUserMeta(user string, fn func(meta *UserMeta) error) error {
+	tx := d.Begin()
+	tx.UpdateUserMeta(user, fn)
+	return tx.Commit()
+}
+
+// GetGroupMeta returns the meta data of group.
+func (d *Dir) GetGroupMeta(group string) (*GroupMeta, error) {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return NewGroupDir(d, group).GetMeta()
+}
+
+// SetGroupMeta replaces the meta data of group with meta.
+func (d *Dir) SetGroupMeta(group string, meta *GroupMeta) error {
+	tx := d.Begin()
+	tx.SetGroupMeta(group, meta)
+	return tx.Commit()
+}
+
+// UpdateGroupMeta calls fn to modify the meta data of group. If fn returns an
+// error the meta data is not changed. fn gets called while the store is locked
+// and must not call methods of the store.
+func (d *Dir) UpdateGroupMeta(group string, fn func(meta *GroupMeta) error) error {
+	tx := d.Begin()
+	tx.UpdateGroupMeta(group, fn)
+	return tx.Commit()
+}
+
+// isImplicitGroup checks whether group is the implicit group of a user. This is
+// only the case if implicit user groups are enabled, there is a user called
+// group and there is no real group with the same name.
+func (d *Dir) isImplicitGroup(group string) (bool, error) {
+	if !d.opts.ImplicitUserGroups {
+		return false, nil
+	}
+	if exists, err := NewGroupDir(d, group).Exists(); err != nil || exists {
+		return false, err
+	}
+	return NewUserFile(d, group).Exists()
+}
+
+// implicitGroupOf adds the implicit group of user to groups if implicit user
+// groups are enabled and there is no real group with the same name.
+func (d *Dir) implicitGroupOf(groups []string, user string) ([]string, error) {
+	if !d.opts.ImplicitUserGroups || contains(groups, user) {
+		return groups, nil
+	}
+	if exists, err := NewGroupDir(d, user).Exists(); err != nil || exists {
+		return groups, err
+	}
+	groups = append(groups, user)
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// walkGroups calls fn for group and every group which is a direct or indirect
+// member of group. fn gets called at most once per group and receives the
+// direct user members of the group. Walking stops as soon as fn returns true.
+// Membership loops are tolerated but reported as a warning to the log. If group
+// is an implicit user group fn is only called for group itself.
+func (d *Dir) walkGroups(group string, fn func(group string, users []string) bool) error {
+	if implicit, err := d.isImplicitGroup(group); err != nil {
+		return err
+	} else if implicit {
+		fn(group, []string{group})
+		return nil
+	}
+	if err := NewGroupDir(d, group).checkExists(); err != nil {
+		return err
+	}
+
+	visited := make(map[string]bool)
+	var path []string
+	var walk func(group string) (bool, error)
+	walk = func(group string) (bool, error) {
+		for i, g := range path {
+			if g == group {
+				loop := append(append([]string{}, path[i:]...), group)
+				wl.Printf("Warning: membership loop detected: %s", strings.Join(loop, " -> "))
+				return false, nil
+			}
+		}
+		if visited[group] {
+			return false, nil
+		}
+		visited[group] = true
+
+		users, groups, err := NewGroupDir(d, group).readMembers()
+		if err != nil {
+			return false, err
+		}
+		if fn(group, users) {
+			return true, nil
+		}
+
+		path = append(path, group)
+		for _, member := range groups {
+			if stop, err := walk(member); err != nil || stop {
+				return stop, err
+			}
+		}
+		path = path[:len(path)-1]
+		return false, nil
+	}
+	_, err := walk(group)
+	return err
+}
+
+// IsMember checks whether user is a member of group. Memberships of nested
+// groups are taken into account.
+func (d *Dir) IsMember(group, user string) (isMember bool, err error) {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	err = d.walkGroups(group, func(_ string, users []string) bool {
+		for _, u := range users {
+			if u == user {
+				isMember = true
+				break
+			}
+		}
+		return isMember
+	})
+	return
+}
+
+// EffectiveMembers returns a sorted list of all users which are members of
+// group either directly or through nested groups.
+func (d *Dir) EffectiveMembers(group string) ([]string, error) {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return d.effectiveMembers(group)
+}
+
+func (d *Dir) effectiveMembers(group string) ([]string, error) {
+	members := make(map[string]bool)
+	err := d.walkGroups(group, func(_ string, users []string) bool {
+		for _, u := range users {
+			members[u] = true
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(members))
+	for u := range members {
+		result = append(result, u)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// listUsers returns the sorted names of all user files inside the store. Files
+// with invalid names are ignored.
+func (d *Dir) listUsers() ([]string, error) {
+	dir, err := openDir(filepath.Join(d.basedir, usersDir))
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	fis, err := dir.Readdir(0)
+	if err != nil {
+		return nil, err
+	}
+	users := []string{}
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && nameRe.MatchString(fi.Name()) {
+			users = append(users, fi.Name())
+		}
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// listGroups returns the sorted names of all group directories inside the
+// store. Directories with invalid names are ignored.
+func (d *Dir) listGroups() ([]string, error) {
+	dir, err := openDir(filepath.Join(d.basedir, groupsDir))
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	fis, err := dir.Readdir(0)
+	if err != nil {
+		return nil, err
+	}
+	groups := []string{}
+	for _, fi := range fis {
+		if fi.IsDir() && nameRe.MatchString(fi.Name()) {
+			groups = append(groups, fi.Name())
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// groupMembers holds the direct user and group members of a group.
+type groupMembers struct {
+	users  []string
+	groups []string
+}
+
+// readAllMembers returns the direct members of all groups inside the store.
+func (d *Dir) readAllMembers() (map[string]groupMembers, error) {
+	groups, err := d.listGroups()
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]groupMembers)
+	for _, group := range groups {
+		var m groupMembers
+		if m.users, m.groups, err = NewGroupDir(d, group).readMembers(); err != nil {
+			return nil, err
+		}
+		all[group] = m
+	}
+	return all, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupsOf returns a sorted list of all groups user is a direct member of. If
+// implicit user groups are enabled this includes the implicit group of user.
+func (d *Dir) GroupsOf(user string) ([]string, error) {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := NewUserFile(d, user).checkExists(); err != nil {
+		return nil, err
+	}
+
+	all, err := d.readAllMembers()
+	if err != nil {
+		return nil, err
+	}
+	return d.implicitGroupOf(directGroupsOf(all, user), user)
+}
+
+func directGroupsOf(all map[string]groupMembers, user string) []string {
+	groups := []string{}
+	for group, m := range all {
+		if contains(m.users, user) {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// EffectiveGroupsOf returns a sorted list of all groups user is a member of,
+// either directly or because one of its groups is a member of another group. If
+// implicit user groups are enabled this includes the implicit group of user.
+// Membership loops are tolerated but reported as a warning to the log.
+func (d *Dir) EffectiveGroupsOf(user string) ([]string, error) {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := NewUserFile(d, user).checkExists(); err != nil {
+		return nil, err
+	}
+
+	all, err := d.readAllMembers()
+	if err != nil {
+		return nil, err
+	}
+	parents := make(map[string][]string)
+	for group, m := range all {
+		for _, member := range m.groups {
+			parents[member] = append(parents[member], group)
+		}
+	}
+
+	visited := make(map[string]bool)
+	var path []string
+	var walk func(group string)
+	walk = func(group string) {
+		for i, g := range path {
+			if g == group {
+				loop := append(append([]string{}, path[i:]...), group)
+				wl.Printf("Warning: membership loop detected: %s", strings.Join(loop, " <- "))
+				return
+			}
+		}
+		if visited[group] {
+			return
+		}
+		visited[group] = true
+
+		path = append(path, group)
+		for _, parent := range parents[group] {
+			walk(parent)
+		}
+		path = path[:len(path)-1]
+	}
+	for _, group := range directGroupsOf(all, user) {
+		walk(group)
+	}
+
+	groups := make([]string, 0, len(visited))
+	for group := range visited {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return d.implicitGroupOf(groups, user)
+}
+
+func filterPrefix(names []string, prefix string) []string {
+	if prefix == "" {
+		return names
+	}
+	filtered := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
+
+// ListUsers returns a sorted list of the names of all users. If prefix is not
+// empty only users whose name starts with prefix are returned.
+func (d *Dir) ListUsers(prefix string) ([]string, error) {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	users, err := d.listUsers()
+	if err != nil {
+		return nil, err
+	}
+	return filterPrefix(users, prefix), nil
+}
+
+// ListGroups returns a sorted list of the names of all groups. If implicit
+// user groups are enabled this includes the implicit groups of all users. If
+// prefix is not empty only groups whose name starts with prefix are returned.
+func (d *Dir) ListGroups(prefix string) ([]string, error) {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	groups, err := d.listGroups()
+	if err != nil {
+		return nil, err
+	}
+	if d.opts.ImplicitUserGroups {
+		users, err := d.listUsers()
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			if !contains(groups, user) {
+				groups = append(groups, user)
+			}
+		}
+		sort.Strings(groups)
+	}
+	return filterPrefix(groups, prefix), nil
+}
+
+// ListMembers returns sorted lists of the direct user and group members of
+// group. Dangling membership links are ignored.
+func (d *Dir) ListMembers(group string) (users, groups []string, err error) {
+	unlock, err := d.lock(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	var implicit bool
+	if implicit, err = d.isImplicitGroup(group); err != nil {
+		return
+	} else if implicit {
+		return []string{group}, []string{}, nil
+	}
+
+	g := NewGroupDir(d, group)
+	if err = g.checkExists(); err != nil {
+		return
+	}
+	if users, groups, err = g.readMembers(); err != nil {
+		return
+	}
+	if users == nil {
+		users = []string{}
+	}
+	if groups == nil {
+		groups = []string{}
+	}
+	sort.Strings(users)
+	sort.Strings(groups)
+	return
+}
@@ -31,9 +31,13 @@
 package store
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -180,7 +184,45 @@ func TestCheckDir(t *testing.T) {
 	}
 }
 
-// TODO: add tests for users and groups dirs checks
+func TestWriteFile(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	filename := filepath.Join(testBaseDir, usersDir, "test-user")
+	for _, content := range []string{"changed: 2016-01-01T00:00:00Z\n", "mail: hugo@example.com\n"} {
+		if err := store.writeFile(filename, []byte(content), 0640); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if data, err := ioutil.ReadFile(filename); err != nil {
+			t.Fatal("unexpected error:", err)
+		} else if string(data) != content {
+			t.Fatalf("file has wrong content, expected '%s', got '%s'", content, data)
+		}
+		if fi, err := os.Stat(filename); err != nil {
+			t.Fatal("unexpected error:", err)
+		} else if fi.Mode().Perm() != 0640 {
+			t.Fatalf("file has wrong permissions: %v", fi.Mode())
+		}
+	}
+
+	if err := store.writeFile(filepath.Join(testBaseDir, "not-existing", "file"), []byte("data"), 0644); err == nil {
+		t.Fatal("writing to a not existing directory should yield an error")
+	}
+
+	if tmpfiles, err := ioutil.ReadDir(filepath.Join(testBaseDir, tmpDir)); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if len(tmpfiles) != 0 {
+		t.Fatalf("there should be no left-over temporary files, found %d", len(tmpfiles))
+	}
+}
 
 func TestAddUser(t *testing.T) {
 	store := NewDir(testBaseDir)
@@ -236,13 +278,62 @@ func TestRemoveUser(t *testing.T) {
 	if err := store.AddUser(testUser); err != nil {
 		t.Fatal("unexpected error:", err)
 	}
-	store.RemoveUser(testUser)
+	for _, group := range []string{"group-a", "group-b", "group-c"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, group := range []string{"group-a", "group-c"} {
+		if err := store.AddUserMember(group, testUser); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if groups, err := store.RemoveUser(testUser); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"group-a", "group-c"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("RemoveUser should report modified groups %v, got %v", expected, groups)
+	}
 
 	if exists, err := fileExists(filepath.Join(testBaseDir, usersDir, testUser)); err != nil {
 		t.Fatal("unexpected error:", err)
 	} else if exists {
 		t.Fatalf("the userfile for '%s' should no longer exist", testUser)
 	}
+	for _, group := range []string{"group-a", "group-c"} {
+		if _, err := os.Lstat(filepath.Join(testBaseDir, groupsDir, group, testUser)); !os.IsNotExist(err) {
+			t.Fatalf("the membership link in group '%s' should no longer exist: %v", group, err)
+		}
+	}
+
+	if _, err := store.RemoveUser(testUser); err == nil {
+		t.Fatal("removing a not existing user should yield an error")
+	}
+
+	// the user file must be kept if a membership link can't be removed, the
+	// failure is caused by a read-only group directory which doesn't stop root
+	if os.Geteuid() == 0 {
+		return
+	}
+	if err := store.AddUser(testUser); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUserMember("group-a", testUser); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := os.Chmod(filepath.Join(testBaseDir, groupsDir, "group-a"), 0555); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, err := store.RemoveUser(testUser); err == nil {
+		t.Fatal("removing a user should fail if a membership link can't be removed")
+	}
+	if err := os.Chmod(filepath.Join(testBaseDir, groupsDir, "group-a"), 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if exists, err := fileExists(filepath.Join(testBaseDir, usersDir, testUser)); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if !exists {
+		t.Fatalf("the userfile for '%s' should still exist after failed removal", testUser)
+	}
 }
 
 func TestAddGroup(t *testing.T) {
@@ -299,13 +390,75 @@ func TestRemoveGroup(t *testing.T) {
 	if err := store.AddGroup(testGroup); err != nil {
 		t.Fatal("unexpected error:", err)
 	}
-	store.RemoveGroup(testGroup)
+	for _, group := range []string{"parent-a", "parent-b", "parent-c"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, group := range []string{"parent-a", "parent-b"} {
+		if err := store.AddGroupMember(group, testGroup); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if groups, err := store.RemoveGroup(testGroup, false); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"parent-a", "parent-b"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("RemoveGroup should report modified groups %v, got %v", expected, groups)
+	}
 
 	if exists, err := fileExists(filepath.Join(testBaseDir, groupsDir, testGroup)); err != nil {
 		t.Fatal("unexpected error:", err)
 	} else if exists {
 		t.Fatalf("the group directory for '%s' should no longer exist", testGroup)
 	}
+	for _, group := range []string{"parent-a", "parent-b"} {
+		if _, err := os.Lstat(filepath.Join(testBaseDir, groupsDir, group, testGroup)); !os.IsNotExist(err) {
+			t.Fatalf("the membership link in group '%s' should no longer exist: %v", group, err)
+		}
+	}
+
+	if _, err := store.RemoveGroup(testGroup, false); err == nil {
+		t.Fatal("removing a not existing group should yield an error")
+	}
+
+	// only empty groups can be removed without force
+	if err := store.AddUser("test-user"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUserMember("parent-a", "test-user"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroupMember("parent-b", "parent-c"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, group := range []string{"parent-a", "parent-b"} {
+		if _, err := store.RemoveGroup(group, false); err == nil {
+			t.Fatalf("removing the non-empty group '%s' without force should yield an error", group)
+		}
+		if exists, err := fileExists(filepath.Join(testBaseDir, groupsDir, group)); err != nil {
+			t.Fatal("unexpected error:", err)
+		} else if !exists {
+			t.Fatalf("the group directory for '%s' should still exist", group)
+		}
+		if _, err := store.RemoveGroup(group, true); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if exists, err := fileExists(filepath.Join(testBaseDir, groupsDir, group)); err != nil {
+			t.Fatal("unexpected error:", err)
+		} else if exists {
+			t.Fatalf("the group directory for '%s' should no longer exist", group)
+		}
+	}
+	if exists, err := fileExists(filepath.Join(testBaseDir, usersDir, "test-user")); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if !exists {
+		t.Fatal("removing a group must not remove its members")
+	}
+	if exists, err := fileExists(filepath.Join(testBaseDir, groupsDir, "parent-c")); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if !exists {
+		t.Fatal("removing a group must not remove its members")
+	}
 }
 
 func TestAddRemoveUserMember(t *testing.T) {
@@ -334,15 +487,35 @@ func TestAddRemoveUserMember(t *testing.T) {
 		t.Fatal("unexpected error:", err)
 	}
 
-	// TODO: write testcases for add function
-	if err := store.AddUserMember(testGroup, testUser); err != ErrNotImplemented {
+	if err := store.AddUserMember("not-existing-group", testUser); err == nil {
+		t.Fatal("adding user to not-existing group should yield an error")
+	}
+
+	linkname := filepath.Join(testBaseDir, groupsDir, testGroup, testUser)
+	if err := store.AddUserMember(testGroup, testUser); err != nil {
 		t.Fatal("unexpected error:", err)
 	}
+	if target, err := os.Readlink(linkname); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if target != filepath.Join("..", "..", usersDir, testUser) {
+		t.Fatalf("membership link points to wrong target: %s", target)
+	}
+	if err := store.AddUserMember(testGroup, testUser); err != nil {
+		t.Fatal("adding user a second time should not yield an error:", err)
+	}
 
-	// TODO: write testcases for remove function
-	if err := store.RemoveUserMember(testGroup, testUser); err != ErrNotImplemented {
+	if err := store.RemoveUserMember(testGroup, testUser); err != nil {
 		t.Fatal("unexpected error:", err)
 	}
+	if _, err := os.Lstat(linkname); !os.IsNotExist(err) {
+		t.Fatal("membership link should no longer exist:", err)
+	}
+	if err := store.RemoveUserMember(testGroup, testUser); err != nil {
+		t.Fatal("removing a user which is not a member should not yield an error:", err)
+	}
+	if err := store.RemoveUserMember("not-existing-group", testUser); err == nil {
+		t.Fatal("removing user from not-existing group should yield an error")
+	}
 }
 
 func TestAddRemoveGroupMember(t *testing.T) {
@@ -371,15 +544,708 @@ func TestAddRemoveGroupMember(t *testing.T) {
 		t.Fatal("unexpected error:", err)
 	}
 
-	// TODO: write testcases for add function
-	if err := store.AddGroupMember(testGroup, testGroup2); err != ErrNotImplemented {
+	if err := store.AddGroupMember("not-existing-group", testGroup2); err == nil {
+		t.Fatal("adding group to not-existing group should yield an error")
+	}
+	if err := store.AddGroupMember(testGroup, testGroup); err == nil {
+		t.Fatal("adding a group to itself should yield an error")
+	}
+
+	linkname := filepath.Join(testBaseDir, groupsDir, testGroup, testGroup2)
+	if err := store.AddGroupMember(testGroup, testGroup2); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if target, err := os.Readlink(linkname); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if target != filepath.Join("..", testGroup2) {
+		t.Fatalf("membership link points to wrong target: %s", target)
+	}
+	if err := store.AddGroupMember(testGroup, testGroup2); err != nil {
+		t.Fatal("adding group a second time should not yield an error:", err)
+	}
+	if err := store.RemoveUserMember(testGroup, testGroup2); err == nil {
+		t.Fatal("removing a group member using RemoveUserMember should yield an error")
+	}
+
+	if err := store.RemoveGroupMember(testGroup, testGroup2); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if _, err := os.Lstat(linkname); !os.IsNotExist(err) {
+		t.Fatal("membership link should no longer exist:", err)
+	}
+	if err := store.RemoveGroupMember(testGroup, testGroup2); err != nil {
+		t.Fatal("removing a group which is not a member should not yield an error:", err)
+	}
+	if err := store.RemoveGroupMember("not-existing-group", testGroup2); err == nil {
+		t.Fatal("removing group from not-existing group should yield an error")
+	}
+}
+
+func TestAddGroupMemberLoop(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for _, group := range []string{"a", "b", "c", "d", "x", "y"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, m := range [][2]string{{"a", "b"}, {"b", "c"}, {"a", "d"}, {"d", "c"}} {
+		if err := store.AddGroupMember(m[0], m[1]); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+
+	err := store.AddGroupMember("c", "a")
+	if err == nil {
+		t.Fatal("creating a membership loop should yield an error")
+	}
+	lerr, ok := err.(*LoopError)
+	if !ok {
+		t.Fatalf("creating a membership loop should yield a LoopError, got: %v", err)
+	}
+	expected := []string{"c", "a", "b", "c"}
+	if !reflect.DeepEqual(lerr.Path, expected) {
+		t.Fatalf("loop path is wrong, expected %v, got %v", expected, lerr.Path)
+	}
+	if _, err := os.Lstat(filepath.Join(testBaseDir, groupsDir, "c", "a")); !os.IsNotExist(err) {
+		t.Fatal("membership link for loop should not exist:", err)
+	}
+
+	if err := store.AddGroupMember("c", "x"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// pre-existing loops must not confuse the loop detection
+	if err := os.Symlink(filepath.Join("..", "y"), filepath.Join(testBaseDir, groupsDir, "x", "y")); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := os.Symlink(filepath.Join("..", "x"), filepath.Join(testBaseDir, groupsDir, "y", "x")); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroupMember("d", "b"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroupMember("y", "a"); err == nil {
+		t.Fatal("creating a membership loop should yield an error")
+	} else if lerr, ok := err.(*LoopError); !ok {
+		t.Fatalf("creating a membership loop should yield a LoopError, got: %v", err)
+	} else if expected := []string{"y", "a", "b", "c", "x", "y"}; !reflect.DeepEqual(lerr.Path, expected) {
+		t.Fatalf("loop path is wrong, expected %v, got %v", expected, lerr.Path)
+	}
+}
+
+func TestEffectiveMembership(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for _, user := range []string{"alice", "bob", "carol", "dave", "eve"} {
+		if err := store.AddUser(user); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, group := range []string{"staff", "devs", "ops", "empty", "x", "y"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, m := range [][2]string{{"staff", "alice"}, {"devs", "bob"}, {"devs", "carol"}, {"ops", "carol"}, {"ops", "dave"}, {"x", "eve"}} {
+		if err := store.AddUserMember(m[0], m[1]); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, m := range [][2]string{{"staff", "devs"}, {"staff", "ops"}, {"devs", "x"}} {
+		if err := store.AddGroupMember(m[0], m[1]); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+
+	// create a loop x -> y -> x behind the back of the store
+	if err := os.Symlink(filepath.Join("..", "x"), filepath.Join(testBaseDir, groupsDir, "y", "x")); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := os.Symlink(filepath.Join("..", "y"), filepath.Join(testBaseDir, groupsDir, "x", "y")); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var logbuf bytes.Buffer
+	wl.SetOutput(&logbuf)
+	defer wl.SetOutput(ioutil.Discard)
+
+	members := []struct {
+		group   string
+		members []string
+	}{
+		{"staff", []string{"alice", "bob", "carol", "dave", "eve"}},
+		{"devs", []string{"bob", "carol", "eve"}},
+		{"ops", []string{"carol", "dave"}},
+		{"x", []string{"eve"}},
+		{"y", []string{"eve"}},
+		{"empty", []string{}},
+	}
+	for _, m := range members {
+		if members, err := store.EffectiveMembers(m.group); err != nil {
+			t.Fatal("unexpected error:", err)
+		} else if !reflect.DeepEqual(members, m.members) {
+			t.Fatalf("wrong effective members for group '%s', expected %v, got %v", m.group, m.members, members)
+		}
+	}
+	if !strings.Contains(logbuf.String(), "loop") {
+		t.Fatalf("membership loop should be reported to the log")
+	}
+
+	isMember := []struct {
+		group    string
+		user     string
+		isMember bool
+	}{
+		{"staff", "alice", true},
+		{"staff", "eve", true},
+		{"devs", "dave", false},
+		{"ops", "dave", true},
+		{"y", "eve", true},
+		{"y", "alice", false},
+		{"empty", "alice", false},
+	}
+	for _, m := range isMember {
+		if isMember, err := store.IsMember(m.group, m.user); err != nil {
+			t.Fatal("unexpected error:", err)
+		} else if isMember != m.isMember {
+			t.Fatalf("IsMember('%s', '%s') should be %v", m.group, m.user, m.isMember)
+		}
+	}
+
+	// dangling links don't count
+	if err := os.Remove(filepath.Join(testBaseDir, usersDir, "dave")); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if isMember, err := store.IsMember("ops", "dave"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if isMember {
+		t.Fatalf("dangling membership links should be ignored")
+	}
+
+	if _, err := store.EffectiveMembers("not-existing-group"); err == nil {
+		t.Fatal("querying members of not existing group should yield an error")
+	}
+	if _, err := store.IsMember("not-existing-group", "alice"); err == nil {
+		t.Fatal("querying members of not existing group should yield an error")
+	}
+}
+
+func TestGroupsOf(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if err := store.AddUser(user); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, group := range []string{"company", "staff", "devs", "ops", "x", "y"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, m := range [][2]string{{"staff", "alice"}, {"devs", "bob"}, {"ops", "bob"}, {"x", "carol"}} {
+		if err := store.AddUserMember(m[0], m[1]); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, m := range [][2]string{{"company", "staff"}, {"staff", "devs"}, {"staff", "ops"}} {
+		if err := store.AddGroupMember(m[0], m[1]); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+
+	// create a loop x -> y -> x behind the back of the store
+	if err := os.Symlink(filepath.Join("..", "x"), filepath.Join(testBaseDir, groupsDir, "y", "x")); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := os.Symlink(filepath.Join("..", "y"), filepath.Join(testBaseDir, groupsDir, "x", "y")); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var logbuf bytes.Buffer
+	wl.SetOutput(&logbuf)
+	defer wl.SetOutput(ioutil.Discard)
+
+	groups := []struct {
+		user      string
+		direct    []string
+		effective []string
+	}{
+		{"alice", []string{"staff"}, []string{"company", "staff"}},
+		{"bob", []string{"devs", "ops"}, []string{"company", "devs", "ops", "staff"}},
+		{"carol", []string{"x"}, []string{"x", "y"}},
+	}
+	for _, g := range groups {
+		if groups, err := store.GroupsOf(g.user); err != nil {
+			t.Fatal("unexpected error:", err)
+		} else if !reflect.DeepEqual(groups, g.direct) {
+			t.Fatalf("wrong groups for user '%s', expected %v, got %v", g.user, g.direct, groups)
+		}
+		if groups, err := store.EffectiveGroupsOf(g.user); err != nil {
+			t.Fatal("unexpected error:", err)
+		} else if !reflect.DeepEqual(groups, g.effective) {
+			t.Fatalf("wrong effective groups for user '%s', expected %v, got %v", g.user, g.effective, groups)
+		}
+	}
+	if !strings.Contains(logbuf.String(), "loop") {
+		t.Fatalf("membership loop should be reported to the log")
+	}
+
+	if err := store.AddUser("dave"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if groups, err := store.EffectiveGroupsOf("dave"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if len(groups) != 0 {
+		t.Fatalf("user without groups should not be a member of any group: %v", groups)
+	}
+
+	if _, err := store.GroupsOf("not-existing-user"); err == nil {
+		t.Fatal("querying groups of not existing user should yield an error")
+	}
+	if _, err := store.EffectiveGroupsOf("not-existing-user"); err == nil {
+		t.Fatal("querying groups of not existing user should yield an error")
+	}
+}
+
+func TestImplicitUserGroups(t *testing.T) {
+	store := NewDirWithOptions(testBaseDir, Options{ImplicitUserGroups: true})
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		if err := store.AddUser(user); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if err := store.AddGroup("alice"); err == nil {
+		t.Fatal("adding a group which shadows an implicit user group should yield an error")
+	}
+	if err := store.AddGroup("staff"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUserMember("staff", "bob"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if members, err := store.EffectiveMembers("alice"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if !reflect.DeepEqual(members, []string{"alice"}) {
+		t.Fatalf("the only member of an implicit user group should be the user, got %v", members)
+	}
+	if isMember, err := store.IsMember("alice", "alice"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if !isMember {
+		t.Fatal("user should be a member of its implicit group")
+	}
+	if isMember, err := store.IsMember("alice", "bob"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if isMember {
+		t.Fatal("user should not be a member of the implicit group of another user")
+	}
+	if groups, err := store.GroupsOf("bob"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"bob", "staff"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("wrong groups for user, expected %v, got %v", expected, groups)
+	}
+	if groups, err := store.EffectiveGroupsOf("alice"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"alice"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("wrong effective groups for user, expected %v, got %v", expected, groups)
+	}
+	if _, err := os.Stat(filepath.Join(testBaseDir, groupsDir, "alice")); !os.IsNotExist(err) {
+		t.Fatal("implicit user groups must not be stored on disk:", err)
+	}
+
+	// without the option there are no implicit groups
+	plain := NewDir(testBaseDir)
+	if _, err := plain.EffectiveMembers("alice"); err == nil {
+		t.Fatal("implicit user groups should only exist if enabled")
+	}
+	if groups, err := plain.GroupsOf("bob"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"staff"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("wrong groups for user, expected %v, got %v", expected, groups)
+	}
+}
+
+func TestSharedNamespace(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if err := store.AddUser("foo"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroup("foo"); err == nil {
+		t.Fatal("adding a group with the name of an existing user should yield an error")
+	}
+	if err := store.AddGroup("bar"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUser("bar"); err == nil {
+		t.Fatal("adding a user with the name of an existing group should yield an error")
+	}
+
+	if err := store.Check(); err != nil {
+		t.Fatalf("check should succeed if there are no collisions: %v", err)
+	}
+
+	// create a collision behind the back of the store
+	if err := NewGroupDir(store, "foo").Add(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.Check(); err == nil {
+		t.Fatal("check should report users and groups sharing the same name")
+	}
+}
+
+func TestRenameUser(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if err := store.AddUser("hugo"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUser("other"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, group := range []string{"staff", "devs", "ops"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, group := range []string{"staff", "ops"} {
+		if err := store.AddUserMember(group, "hugo"); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if err := NewUserFile(store, "hugo").Update(func(meta *UserMeta) error {
+		meta.Mail = "hugo@example.com"
+		return nil
+	}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for _, name := range []string{"in valid", "other", "devs"} {
+		if _, err := store.RenameUser("hugo", name); err == nil {
+			t.Fatalf("renaming user to '%s' should yield an error", name)
+		}
+	}
+	if _, err := store.RenameUser("not-existing-user", "foo"); err == nil {
+		t.Fatal("renaming a not existing user should yield an error")
+	}
+
+	if groups, err := store.RenameUser("hugo", "hhuber"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"ops", "staff"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("RenameUser should report modified groups %v, got %v", expected, groups)
+	}
+
+	if exists, err := NewUserFile(store, "hugo").Exists(); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if exists {
+		t.Fatal("old user should no longer exist")
+	}
+	if meta, err := NewUserFile(store, "hhuber").Get(); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if meta.Mail != "hugo@example.com" {
+		t.Fatal("renamed user should keep its meta data")
+	}
+	if groups, err := store.GroupsOf("hhuber"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"ops", "staff"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("renamed user should keep its groups %v, got %v", expected, groups)
+	}
+	if err := store.Check(); err != nil {
+		t.Fatalf("store should be consistent after rename: %v", err)
+	}
+}
+
+func TestRenameGroup(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		if err := store.AddUser(user); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, group := range []string{"company", "staff", "devs", "other"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if err := store.AddUserMember("devs", "alice"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, m := range [][2]string{{"company", "devs"}, {"staff", "devs"}, {"devs", "other"}} {
+		if err := store.AddGroupMember(m[0], m[1]); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+
+	for _, name := range []string{"in valid", "other", "bob"} {
+		if _, err := store.RenameGroup("devs", name); err == nil {
+			t.Fatalf("renaming group to '%s' should yield an error", name)
+		}
+	}
+	if _, err := store.RenameGroup("not-existing-group", "foo"); err == nil {
+		t.Fatal("renaming a not existing group should yield an error")
+	}
+
+	if groups, err := store.RenameGroup("devs", "developers"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"company", "staff"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("RenameGroup should report modified groups %v, got %v", expected, groups)
+	}
+
+	if exists, err := NewGroupDir(store, "devs").Exists(); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if exists {
+		t.Fatal("old group should no longer exist")
+	}
+	if isMember, err := store.IsMember("developers", "alice"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if !isMember {
+		t.Fatal("renamed group should keep its members")
+	}
+	if groups, err := store.EffectiveGroupsOf("alice"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"company", "developers", "staff"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("renamed group should keep its parents %v, got %v", expected, groups)
+	}
+	if err := store.Check(); err != nil {
+		t.Fatalf("store should be consistent after rename: %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if users, err := store.ListUsers(""); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if len(users) != 0 {
+		t.Fatalf("empty store should have no users: %v", users)
+	}
+
+	for _, user := range []string{"bob", "alice", "adam"} {
+		if err := store.AddUser(user); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, group := range []string{"staff", "devs", "admins"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, m := range [][2]string{{"staff", "bob"}, {"staff", "alice"}, {"devs", "alice"}} {
+		if err := store.AddUserMember(m[0], m[1]); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, m := range [][2]string{{"staff", "devs"}, {"staff", "admins"}} {
+		if err := store.AddGroupMember(m[0], m[1]); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	// temporary files must not show up anywhere
+	if _, err := store.getTempDir(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	lists := []struct {
+		prefix string
+		users  []string
+		groups []string
+	}{
+		{"", []string{"adam", "alice", "bob"}, []string{"admins", "devs", "staff"}},
+		{"a", []string{"adam", "alice"}, []string{"admins"}},
+		{"d", []string{}, []string{"devs"}},
+		{"x", []string{}, []string{}},
+	}
+	for _, l := range lists {
+		if users, err := store.ListUsers(l.prefix); err != nil {
+			t.Fatal("unexpected error:", err)
+		} else if !reflect.DeepEqual(users, l.users) {
+			t.Fatalf("wrong users for prefix '%s', expected %v, got %v", l.prefix, l.users, users)
+		}
+		if groups, err := store.ListGroups(l.prefix); err != nil {
+			t.Fatal("unexpected error:", err)
+		} else if !reflect.DeepEqual(groups, l.groups) {
+			t.Fatalf("wrong groups for prefix '%s', expected %v, got %v", l.prefix, l.groups, groups)
+		}
+	}
+
+	if users, groups, err := store.ListMembers("staff"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if !reflect.DeepEqual(users, []string{"alice", "bob"}) || !reflect.DeepEqual(groups, []string{"admins", "devs"}) {
+		t.Fatalf("wrong members of group staff: users %v, groups %v", users, groups)
+	}
+	if users, groups, err := store.ListMembers("admins"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if len(users) != 0 || len(groups) != 0 {
+		t.Fatalf("group admins should have no members: users %v, groups %v", users, groups)
+	}
+	if _, _, err := store.ListMembers("not-existing-group"); err == nil {
+		t.Fatal("listing members of not existing group should yield an error")
+	}
+
+	implicit := NewDirWithOptions(testBaseDir, Options{ImplicitUserGroups: true})
+	if groups, err := implicit.ListGroups("a"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"adam", "admins", "alice"}; !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("wrong groups with implicit user groups, expected %v, got %v", expected, groups)
+	}
+	if users, groups, err := implicit.ListMembers("bob"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if !reflect.DeepEqual(users, []string{"bob"}) || len(groups) != 0 {
+		t.Fatalf("wrong members of implicit group: users %v, groups %v", users, groups)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
 		t.Fatal("unexpected error:", err)
 	}
+	defer os.RemoveAll(testBaseDir)
 
-	// TODO: write testcases for remove function
-	if err := store.RemoveGroupMember(testGroup, testGroup2); err != ErrNotImplemented {
+	if err := store.Init(); err != nil {
 		t.Fatal("unexpected error:", err)
 	}
+	if err := store.AddUser("hugo"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroup("admins"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddUserMember("admins", "hugo"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for _, err := range []error{
+		store.AddUser("hugo"),
+		store.AddUser("admins"),
+		store.AddGroup("hugo"),
+		store.AddGroup("admins"),
+	} {
+		if !IsExist(err) || IsNotExist(err) {
+			t.Fatalf("error should report an existing name, got: %v", err)
+		}
+	}
+	if _, err := store.RenameUser("hugo", "admins"); !IsExist(err) {
+		t.Fatalf("error should report an existing name, got: %v", err)
+	}
+
+	_, rerr := store.RemoveUser("fredl")
+	_, gerr := store.GetGroupMeta("devs")
+	for _, err := range []error{
+		store.AddUserMember("admins", "fredl"),
+		store.AddGroupMember("admins", "devs"),
+		store.SetUserMeta("fredl", &UserMeta{}),
+		rerr,
+		gerr,
+	} {
+		if !IsNotExist(err) || IsExist(err) {
+			t.Fatalf("error should report a missing user or group, got: %v", err)
+		}
+	}
+	_, uerr := store.GetUserMeta("fredl")
+	for _, err := range []error{
+		store.SetUserMeta("fredl", &UserMeta{}),
+		store.SetGroupMeta("devs", &GroupMeta{}),
+		uerr,
+		gerr,
+	} {
+		if _, ok := cause(err).(*NotExistError); !ok {
+			t.Fatalf("error should be a NotExistError, got: %v", err)
+		}
+	}
+
+	if err := store.AddUser("-hugo"); err == nil {
+		t.Fatal("adding a user with an invalid name should throw an error")
+	} else if _, ok := err.(*InvalidNameError); !ok {
+		t.Fatalf("error should report an invalid name, got: %v", err)
+	}
+	if err := store.AddGroupMember("admins", "admins"); err == nil {
+		t.Fatal("adding a group to itself should throw an error")
+	} else if _, ok := err.(*SelfMemberError); !ok {
+		t.Fatalf("error should report a self membership, got: %v", err)
+	}
+	if _, err := store.RemoveGroup("admins", false); err == nil {
+		t.Fatal("removing a non-empty group should throw an error")
+	} else if _, ok := err.(*NotEmptyError); !ok {
+		t.Fatalf("error should report a non-empty group, got: %v", err)
+	}
 }
 
 func TestMain(m *testing.M) {
@@ -391,6 +1257,10 @@ func TestMain(m *testing.M) {
 		fmt.Println("Error creating store base directory for GroupDir tests:", err)
 		os.Exit(-1)
 	}
+	if err := os.MkdirAll(filepath.Join(testBaseDirGroupDir, usersDir), 0755); err != nil {
+		fmt.Println("Error creating store base directory for GroupDir tests:", err)
+		os.Exit(-1)
+	}
 
 	testStoreUserFile = NewDir(testBaseDirUserFile)
 	testStoreGroupDir = NewDir(testBaseDirGroupDir)
@@ -0,0 +1,531 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrTxDone is returned by Tx.Commit if the transaction has already been
+	// committed or rolled back.
+	ErrTxDone = errors.New("whawty.groups.store: transaction has already been committed or rolled back")
+)
+
+// RollbackError is returned by Tx.Commit if a change failed and not all changes
+// which had already been applied could be reverted. Err is the error of the
+// failed change.
+type RollbackError struct {
+	Err          error
+	RollbackErrs []error
+}
+
+func (e *RollbackError) Error() string {
+	msgs := make([]string, 0, len(e.RollbackErrs))
+	for _, err := range e.RollbackErrs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%v (rollback failed: %s)", e.Err, strings.Join(msgs, "; "))
+}
+
+// txOp is a single change staged in a transaction. prepare is called right
+// before apply and returns a function which restores the state of the store
+// as it was before apply was called. The undo function must also work if
+// apply failed half-way through. apply returns the audit record of the change
+// or nil if nothing was changed.
+type txOp struct {
+	prepare func() (undo func() error, err error)
+	apply   func() (*AuditRecord, error)
+}
+
+// Tx stages changes to the store which are applied by Commit. Either all of
+// the changes are applied or none of them. Use Dir.Begin to create it. A Tx
+// must not be used concurrently.
+type Tx struct {
+	store *Dir
+	ops   []txOp
+	done  bool
+}
+
+// Begin starts a new transaction.
+func (d *Dir) Begin() *Tx {
+	return &Tx{store: d}
+}
+
+func (tx *Tx) stage(prepare func() (func() error, error), apply func() (*AuditRecord, error)) {
+	tx.ops = append(tx.ops, txOp{prepare, apply})
+}
+
+// Commit applies all staged changes in the order they were staged while holding
+// an exclusive lock on the store. If any change fails all changes applied so
+// far get reverted and the error of the failed change is returned. After all
+// changes were applied they are recorded in the audit log and, if git mode is
+// enabled, committed to the git repository. If this fails the changes get
+// reverted as well.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	unlock, err := tx.store.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var undos []func() error
+	var records []*AuditRecord
+	for _, op := range tx.ops {
+		undo, err := op.prepare()
+		if err != nil {
+			return rollback(undos, err)
+		}
+		undos = append(undos, undo)
+		record, err := op.apply()
+		if err != nil {
+			return rollback(undos, err)
+		}
+		if record != nil {
+			records = append(records, record)
+		}
+	}
+	undo, err := tx.store.undoAuditLog()
+	if err != nil {
+		return rollback(undos, err)
+	}
+	undos = append(undos, undo)
+	if err := tx.store.audit(records...); err != nil {
+		return rollback(undos, err)
+	}
+	if err := tx.store.gitCommit(records); err != nil {
+		return rollback(undos, err)
+	}
+	return nil
+}
+
+func rollback(undos []func() error, err error) error {
+	var errs []error
+	for i := len(undos) - 1; i >= 0; i-- {
+		if uerr := undos[i](); uerr != nil {
+			errs = append(errs, uerr)
+		}
+	}
+	if len(errs) > 0 {
+		return &RollbackError{err, errs}
+	}
+	return err
+}
+
+// Rollback discards all staged changes.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.ops = nil
+	return nil
+}
+
+// undoFile returns a function which restores the current contents of filename.
+// If the file does not exist the function removes it.
+func (d *Dir) undoFile(filename string) (func() error, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		return func() error {
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}, nil
+	}
+	return func() error {
+		return d.writeFile(filename, data, 0644)
+	}, nil
+}
+
+// undoLink returns a function which restores the current state of the link
+// called member inside group.
+func (d *Dir) undoLink(group, member, target string) (func() error, error) {
+	g := NewGroupDir(d, group)
+	linked, err := g.hasLink(member, target)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		isLinked, err := g.hasLink(member, target)
+		if err != nil {
+			return err
+		}
+		switch {
+		case linked && !isLinked:
+			return g.addLink(member, target)
+		case !linked && isLinked:
+			return g.removeLink(member, target)
+		}
+		return nil
+	}, nil
+}
+
+// undoLinks returns a function which restores the current state of all links
+// called member inside any group.
+func (d *Dir) undoLinks(member, target string) (func() error, error) {
+	groups, err := d.listGroups()
+	if err != nil {
+		return nil, err
+	}
+	var undos []func() error
+	for _, group := range groups {
+		undo, err := d.undoLink(group, member, target)
+		if err != nil {
+			return nil, err
+		}
+		undos = append(undos, undo)
+	}
+	return undoAll(undos...), nil
+}
+
+// undoGroupDir returns a function which restores the current state of the
+// group directory including its meta data file and all links inside it. If the
+// group does not exist the function removes it.
+func (d *Dir) undoGroupDir(group string) (func() error, error) {
+	g := NewGroupDir(d, group)
+	exists, err := g.Exists()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return g.Remove, nil
+	}
+
+	meta, err := ioutil.ReadFile(g.getMetafilename())
+	if err != nil {
+		return nil, err
+	}
+	links, err := g.readLinks()
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		if exists, err := g.Exists(); err != nil || exists {
+			return err
+		}
+		return g.create(meta, links)
+	}, nil
+}
+
+// undoAll combines multiple undo functions which get called in the given order.
+func undoAll(undos ...func() error) func() error {
+	return func() error {
+		for _, undo := range undos {
+			if err := undo(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// AddUser stages adding user to the store, see Dir.AddUser.
+func (tx *Tx) AddUser(user string) {
+	d := tx.store
+	tx.stage(func() (func() error, error) {
+		return d.undoFile(NewUserFile(d, user).getFilename())
+	}, func() (*AuditRecord, error) {
+		if err := d.addUser(user); err != nil {
+			return nil, err
+		}
+		return d.newAuditRecord(AuditAddUser, user, "", nil, nil), nil
+	})
+}
+
+// RemoveUser stages removing user from the store, see Dir.RemoveUser.
+func (tx *Tx) RemoveUser(user string) {
+	tx.removeUser(user, nil)
+}
+
+func (tx *Tx) removeUser(user string, groups *[]string) {
+	d := tx.store
+	tx.stage(func() (func() error, error) {
+		undoUser, err := d.undoFile(NewUserFile(d, user).getFilename())
+		if err != nil {
+			return nil, err
+		}
+		undoLinks, err := d.undoLinks(user, userLinkTarget(user))
+		if err != nil {
+			return nil, err
+		}
+		return undoAll(undoUser, undoLinks), nil
+	}, func() (*AuditRecord, error) {
+		removed, err := d.removeUser(user)
+		if err != nil {
+			return nil, err
+		}
+		if groups != nil {
+			*groups = removed
+		}
+		return d.newAuditRecord(AuditRemoveUser, user, "", removed, nil), nil
+	})
+}
+
+// RenameUser stages renaming the user oldName to newName, see Dir.RenameUser.
+func (tx *Tx) RenameUser(oldName, newName string) {
+	tx.renameUser(oldName, newName, nil)
+}
+
+func (tx *Tx) renameUser(oldName, newName string, groups *[]string) {
+	d := tx.store
+	tx.stage(func() (func() error, error) {
+		var undos []func() error
+		for _, name := range []string{newName, oldName} {
+			undoUser, err := d.undoFile(NewUserFile(d, name).getFilename())
+			if err != nil {
+				return nil, err
+			}
+			undoLinks, err := d.undoLinks(name, userLinkTarget(name))
+			if err != nil {
+				return nil, err
+			}
+			undos = append(undos, undoUser, undoLinks)
+		}
+		return undoAll(undos...), nil
+	}, func() (*AuditRecord, error) {
+		renamed, err := d.renameUser(oldName, newName)
+		if err != nil {
+			return nil, err
+		}
+		if groups != nil {
+			*groups = renamed
+		}
+		return d.newAuditRecord(AuditRenameUser, oldName, "", oldName, newName), nil
+	})
+}
+
+// AddGroup stages adding group to the store, see Dir.AddGroup.
+func (tx *Tx) AddGroup(group string) {
+	d := tx.store
+	tx.stage(func() (func() error, error) {
+		return d.undoGroupDir(group)
+	}, func() (*AuditRecord, error) {
+		if err := d.addGroup(group); err != nil {
+			return nil, err
+		}
+		return d.newAuditRecord(AuditAddGroup, group, "", nil, nil), nil
+	})
+}
+
+// RemoveGroup stages removing group from the store, see Dir.RemoveGroup.
+func (tx *Tx) RemoveGroup(group string, force bool) {
+	tx.removeGroup(group, force, nil)
+}
+
+func (tx *Tx) removeGroup(group string, force bool, groups *[]string) {
+	d := tx.store
+	tx.stage(func() (func() error, error) {
+		undoGroup, err := d.undoGroupDir(group)
+		if err != nil {
+			return nil, err
+		}
+		undoLinks, err := d.undoLinks(group, groupLinkTarget(group))
+		if err != nil {
+			return nil, err
+		}
+		return undoAll(undoGroup, undoLinks), nil
+	}, func() (*AuditRecord, error) {
+		removed, err := d.removeGroup(group, force)
+		if err != nil {
+			return nil, err
+		}
+		if groups != nil {
+			*groups = removed
+		}
+		return d.newAuditRecord(AuditRemoveGroup, group, "", removed, nil), nil
+	})
+}
+
+// RenameGroup stages renaming the group oldName to newName, see
+// Dir.RenameGroup.
+func (tx *Tx) RenameGroup(oldName, newName string) {
+	tx.renameGroup(oldName, newName, nil)
+}
+
+func (tx *Tx) renameGroup(oldName, newName string, groups *[]string) {
+	d := tx.store
+	tx.stage(func() (func() error, error) {
+		var undos []func() error
+		for _, name := range []string{newName, oldName} {
+			undoGroup, err := d.undoGroupDir(name)
+			if err != nil {
+				return nil, err
+			}
+			undoLinks, err := d.undoLinks(name, groupLinkTarget(name))
+			if err != nil {
+				return nil, err
+			}
+			undos = append(undos, undoGroup, undoLinks)
+		}
+		return undoAll(undos...), nil
+	}, func() (*AuditRecord, error) {
+		renamed, err := d.renameGroup(oldName, newName)
+		if err != nil {
+			return nil, err
+		}
+		if groups != nil {
+			*groups = renamed
+		}
+		return d.newAuditRecord(AuditRenameGroup, oldName, "", oldName, newName), nil
+	})
+}
+
+// stageLink stages a change of the link called member inside group using fn.
+// The audit record contains the state of the link before and after the change.
+func (tx *Tx) stageLink(op, group, member, target string, fn func() error) {
+	d := tx.store
+	tx.stage(func() (func() error, error) {
+		return d.undoLink(group, member, target)
+	}, func() (*AuditRecord, error) {
+		g := NewGroupDir(d, group)
+		before, err := g.hasLink(member, target)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(); err != nil {
+			return nil, err
+		}
+		after, err := g.hasLink(member, target)
+		if err != nil {
+			return nil, err
+		}
+		return d.newAuditRecord(op, group, member, before, after), nil
+	})
+}
+
+// AddUserMember stages adding user to group, see Dir.AddUserMember.
+func (tx *Tx) AddUserMember(group, user string) {
+	tx.stageLink(AuditAddUserMember, group, user, userLinkTarget(user), func() error {
+		return tx.store.addUserMember(group, user)
+	})
+}
+
+// RemoveUserMember stages removing user from group, see Dir.RemoveUserMember.
+func (tx *Tx) RemoveUserMember(group, user string) {
+	tx.stageLink(AuditRemoveUserMember, group, user, userLinkTarget(user), func() error {
+		return tx.store.removeUserMember(group, user)
+	})
+}
+
+// AddGroupMember stages adding groupToAdd to group, see Dir.AddGroupMember.
+func (tx *Tx) AddGroupMember(group, groupToAdd string) {
+	tx.stageLink(AuditAddGroupMember, group, groupToAdd, groupLinkTarget(groupToAdd), func() error {
+		return tx.store.addGroupMember(group, groupToAdd)
+	})
+}
+
+// RemoveGroupMember stages removing groupToRemove from group, see
+// Dir.RemoveGroupMember.
+func (tx *Tx) RemoveGroupMember(group, groupToRemove string) {
+	tx.stageLink(AuditRemoveGroupMember, group, groupToRemove, groupLinkTarget(groupToRemove), func() error {
+		return tx.store.removeGroupMember(group, groupToRemove)
+	})
+}
+
+// SetUserMeta stages replacing the meta data of user, see Dir.SetUserMeta.
+func (tx *Tx) SetUserMeta(user string, meta *UserMeta) {
+	tx.UpdateUserMeta(user, func(m *UserMeta) error {
+		*m = *meta
+		return nil
+	})
+}
+
+// UpdateUserMeta stages modifying the meta data of user, see
+// Dir.UpdateUserMeta. fn gets called during Commit while the store is locked and
+// must not call methods of the store.
+func (tx *Tx) UpdateUserMeta(user string, fn func(meta *UserMeta) error) {
+	d := tx.store
+	tx.stage(func() (func() error, error) {
+		return d.undoFile(NewUserFile(d, user).getFilename())
+	}, func() (*AuditRecord, error) {
+		u := NewUserFile(d, user)
+		before, err := u.Get()
+		if err != nil {
+			return nil, err
+		}
+		if err := u.Update(fn); err != nil {
+			return nil, err
+		}
+		after, err := u.Get()
+		if err != nil {
+			return nil, err
+		}
+		before.Extra, after.Extra = jsonExtra(before.Extra), jsonExtra(after.Extra)
+		return d.newAuditRecord(AuditSetUserMeta, user, "", before, after), nil
+	})
+}
+
+// SetGroupMeta stages replacing the meta data of group, see Dir.SetGroupMeta.
+func (tx *Tx) SetGroupMeta(group string, meta *GroupMeta) {
+	tx.UpdateGroupMeta(group, func(m *GroupMeta) error {
+		*m = *meta
+		return nil
+	})
+}
+
+// UpdateGroupMeta stages modifying the meta data of group, see
+// Dir.UpdateGroupMeta. fn gets called during Commit while the store is locked and
+// must not call methods of the store.
+func (tx *Tx) UpdateGroupMeta(group string, fn func(meta *GroupMeta) error) {
+	d := tx.store
+	tx.stage(func() (func() error, error) {
+		return d.undoFile(NewGroupDir(d, group).getMetafilename())
+	}, func() (*AuditRecord, error) {
+		g := NewGroupDir(d, group)
+		before, err := g.GetMeta()
+		if err != nil {
+			return nil, err
+		}
+		if err := g.UpdateMeta(fn); err != nil {
+			return nil, err
+		}
+		after, err := g.GetMeta()
+		if err != nil {
+			return nil, err
+		}
+		before.Extra, after.Extra = jsonExtra(before.Extra), jsonExtra(after.Extra)
+		return d.newAuditRecord(AuditSetGroupMeta, group, "", before, after), nil
+	})
+}
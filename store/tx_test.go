@@ -0,0 +1,202 @@
+//
+// Copyright (c) 2016 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.groups nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// snapshotStore returns the contents of all files and the targets of all
+// symlinks inside the users and groups directories.
+func snapshotStore(t *testing.T, basedir string) map[string]string {
+	snapshot := make(map[string]string)
+	for _, dir := range []string{usersDir, groupsDir} {
+		err := filepath.Walk(filepath.Join(basedir, dir), func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			switch {
+			case fi.Mode()&os.ModeSymlink != 0:
+				target, err := os.Readlink(path)
+				snapshot[path] = "-> " + target
+				return err
+			case fi.IsDir():
+				snapshot[path] = "/"
+			default:
+				data, err := ioutil.ReadFile(path)
+				snapshot[path] = string(data)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	return snapshot
+}
+
+func TestTxCommit(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := store.AddGroup("company"); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	tx := store.Begin()
+	tx.AddGroup("devs")
+	for _, user := range []string{"alice", "bob", "carol"} {
+		tx.AddUser(user)
+		tx.AddUserMember("devs", user)
+	}
+	tx.AddGroupMember("company", "devs")
+	tx.SetGroupMeta("devs", &GroupMeta{Description: "developers"})
+
+	if exists, err := NewGroupDir(store, "devs").Exists(); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if exists {
+		t.Fatal("changes must not be applied before commit")
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if members, err := store.EffectiveMembers("company"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if expected := []string{"alice", "bob", "carol"}; !reflect.DeepEqual(members, expected) {
+		t.Fatalf("wrong members after commit, expected %v, got %v", expected, members)
+	}
+	if meta, err := store.GetGroupMeta("devs"); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if meta.Description != "developers" {
+		t.Fatal("meta data was not set by commit")
+	}
+
+	if err := tx.Commit(); err != ErrTxDone {
+		t.Fatal("committing a transaction twice should fail, got:", err)
+	}
+
+	tx = store.Begin()
+	tx.RemoveGroup("devs", true)
+	if err := tx.Rollback(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := tx.Commit(); err != ErrTxDone {
+		t.Fatal("committing a rolled back transaction should fail, got:", err)
+	}
+	if exists, err := NewGroupDir(store, "devs").Exists(); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if !exists {
+		t.Fatal("rolled back changes must not be applied")
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	store := NewDir(testBaseDir)
+
+	if err := os.Mkdir(testBaseDir, 0755); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer os.RemoveAll(testBaseDir)
+
+	if err := store.Init(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		if err := store.AddUser(user); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, group := range []string{"company", "staff", "devs", "ops"} {
+		if err := store.AddGroup(group); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, m := range [][2]string{{"staff", "alice"}, {"devs", "alice"}, {"devs", "bob"}} {
+		if err := store.AddUserMember(m[0], m[1]); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	for _, m := range [][2]string{{"company", "staff"}, {"staff", "devs"}, {"devs", "ops"}} {
+		if err := store.AddGroupMember(m[0], m[1]); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if err := store.SetUserMeta("bob", &UserMeta{Mail: "bob@example.com"}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	before := snapshotStore(t, testBaseDir)
+
+	tx := store.Begin()
+	tx.AddUser("carol")
+	tx.AddGroup("new")
+	tx.AddUserMember("new", "carol")
+	tx.AddGroupMember("new", "ops")
+	tx.AddUserMember("ops", "carol")
+	tx.RemoveUserMember("staff", "alice")
+	tx.RemoveGroupMember("company", "staff")
+	tx.SetUserMeta("bob", &UserMeta{Mail: "robert@example.com"})
+	tx.SetGroupMeta("ops", &GroupMeta{Description: "operations"})
+	tx.RemoveUser("alice")
+	tx.RemoveGroup("devs", true)
+	tx.AddGroupMember("ops", "company")
+	tx.AddGroupMember("company", "new")
+	tx.AddGroupMember("ops", "new")
+
+	err := tx.Commit()
+	if err == nil {
+		t.Fatal("commit of a transaction creating a loop should fail")
+	}
+	if _, ok := err.(*LoopError); !ok {
+		t.Fatalf("commit should return the error of the failed change, got: %v", err)
+	}
+
+	after := snapshotStore(t, testBaseDir)
+	if !reflect.DeepEqual(before, after) {
+		t.Fatalf("store should be unchanged after failed commit\nbefore: %v\nafter: %v", before, after)
+	}
+	if err := store.Check(); err != nil {
+		t.Fatalf("store should be consistent after failed commit: %v", err)
+	}
+}
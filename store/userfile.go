@@ -32,6 +32,7 @@ package store
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"time"
@@ -58,35 +59,119 @@ func (u *UserFile) getFilename() string {
 	return filepath.Join(u.store.basedir, usersDir, u.user)
 }
 
+// UserMeta contains the meta data of a user as stored in the user file. All
+// fields which are not known are stored in Extra.
+type UserMeta struct {
+	FirstName string                 `yaml:"firstname,omitempty" json:"firstname,omitempty"`
+	LastName  string                 `yaml:"lastname,omitempty" json:"lastname,omitempty"`
+	Mail      string                 `yaml:"mail,omitempty" json:"mail,omitempty"`
+	Changed   time.Time              `yaml:"changed" json:"changed"`
+	Extra     map[string]interface{} `yaml:",inline" json:"extra,omitempty"`
+}
+
+// userMetaKeys are the keys of the user file which are stored in the fields of
+// UserMeta. They must not be used in Extra.
+var userMetaKeys = []string{"firstname", "lastname", "mail", "changed"}
+
+// ReservedKeyError is returned when the Extra map of meta data contains a key
+// which is reserved for one of the well-known fields.
+type ReservedKeyError struct {
+	Key string
+}
+
+func (e *ReservedKeyError) Error() string {
+	return fmt.Sprintf("whawty.groups.store: meta data key '%s' is reserved and can't be used in Extra", e.Key)
+}
+
+// checkExtraKeys makes sure extra doesn't contain any of the reserved keys.
+func checkExtraKeys(extra map[string]interface{}, reserved []string) error {
+	for _, key := range reserved {
+		if _, exists := extra[key]; exists {
+			return &ReservedKeyError{key}
+		}
+	}
+	return nil
+}
+
 // Add creates the user file. It is an error if the user already exists.
 func (u *UserFile) Add() (err error) {
 	var exists bool
 	if exists, err = u.Exists(); err != nil {
 		return
 	} else if exists {
-		return fmt.Errorf("whawty.groups.store: user '%s' already exists", u.user)
+		return &ExistError{"user", u.user, "user"}
+	}
+	return u.writeMeta(&UserMeta{Changed: time.Now()})
+}
+
+func (u *UserFile) writeMeta(meta *UserMeta) error {
+	if err := checkExtraKeys(meta.Extra, userMetaKeys); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		return err
 	}
-	var file *os.File
-	file, err = os.Create(u.getFilename())
-	defer file.Close()
+	return u.store.writeFile(u.getFilename(), data, 0644)
+}
 
-	m := make(map[string]interface{})
-	m["changed"] = time.Now()
+// Get reads the meta data from the user file. It is an error if the user does
+// not exist.
+func (u *UserFile) Get() (meta *UserMeta, err error) {
+	if err = u.checkExists(); err != nil {
+		return
+	}
 	var data []byte
-	if data, err = yaml.Marshal(m); err != nil {
+	if data, err = ioutil.ReadFile(u.getFilename()); err != nil {
 		return
 	}
-	file.Write(data)
-	return nil
+	meta = &UserMeta{}
+	if err = yaml.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("whawty.groups.store: can't parse user file of '%s': %v", u.user, err)
+	}
+	return
+}
+
+// Set replaces the meta data in the user file with meta. The changed timestamp
+// is set to the current time. It is an error if the user does not exist.
+func (u *UserFile) Set(meta *UserMeta) error {
+	if err := u.checkExists(); err != nil {
+		return err
+	}
+	m := *meta
+	m.Changed = time.Now()
+	return u.writeMeta(&m)
+}
+
+// Update reads the meta data from the user file, calls fn to modify it and
+// writes it back. If fn returns an error the user file is not changed. The
+// changed timestamp is set to the current time.
+func (u *UserFile) Update(fn func(meta *UserMeta) error) error {
+	meta, err := u.Get()
+	if err != nil {
+		return err
+	}
+	if err := fn(meta); err != nil {
+		return err
+	}
+	return u.Set(meta)
 }
 
 // Remove deletes the user file.
-func (u *UserFile) Remove() {
-	os.Remove(u.getFilename())
-	return
+func (u *UserFile) Remove() error {
+	return os.Remove(u.getFilename())
 }
 
 // Exists checks if user exists.
 func (u *UserFile) Exists() (exists bool, err error) {
 	return fileExists(u.getFilename())
 }
+
+func (u *UserFile) checkExists() error {
+	if exists, err := u.Exists(); err != nil {
+		return err
+	} else if !exists {
+		return &NotExistError{"user", u.user}
+	}
+	return nil
+}
@@ -31,9 +31,12 @@
 package store
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAddRemoveUser(t *testing.T) {
@@ -82,3 +85,113 @@ func TestExistsUser(t *testing.T) {
 		t.Fatal("file for test user should exist")
 	}
 }
+
+func TestUserMeta(t *testing.T) {
+	username := "test-meta-user"
+
+	u := NewUserFile(testStoreUserFile, username)
+
+	if _, err := u.Get(); err == nil {
+		t.Fatal("reading meta data of not existing user should yield an error")
+	} else if _, ok := err.(*NotExistError); !ok {
+		t.Fatal("reading meta data of not existing user should yield a NotExistError, got:", err)
+	}
+	if err := u.Set(&UserMeta{FirstName: "Hugo"}); err == nil {
+		t.Fatal("setting meta data of not existing user should yield an error")
+	}
+	if exists, err := u.Exists(); err != nil {
+		t.Fatal("unexpected error:", err)
+	} else if exists {
+		t.Fatal("setting meta data must not create the user")
+	}
+
+	if err := u.Add(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer u.Remove()
+
+	meta, err := u.Get()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if meta.Changed.IsZero() {
+		t.Fatal("newly added user should have a changed timestamp")
+	}
+	added := meta.Changed
+
+	time.Sleep(10 * time.Millisecond)
+	meta.FirstName = "Hugo"
+	meta.LastName = "Huber"
+	meta.Mail = "hugo@example.com"
+	meta.Extra = map[string]interface{}{"room": "B-101"}
+	if err := u.Set(meta); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if meta, err = u.Get(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, key := range []string{"firstname", "lastname", "mail", "changed"} {
+		m := *meta
+		m.Extra = map[string]interface{}{key: "x"}
+		if err := u.Set(&m); err == nil {
+			t.Fatalf("setting meta data with reserved key '%s' in Extra should yield an error", key)
+		} else if _, ok := err.(*ReservedKeyError); !ok {
+			t.Fatalf("error should report the reserved key '%s', got: %v", key, err)
+		}
+	}
+	if meta.FirstName != "Hugo" || meta.LastName != "Huber" || meta.Mail != "hugo@example.com" {
+		t.Fatalf("meta data was not stored correctly: %+v", meta)
+	}
+	if meta.Extra["room"] != "B-101" {
+		t.Fatalf("extra meta data was not stored correctly: %+v", meta.Extra)
+	}
+	if !meta.Changed.After(added) {
+		t.Fatal("Set should update the changed timestamp")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(testBaseDirUserFile, usersDir, username))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, key := range []string{"firstname: Hugo", "lastname: Huber", "mail: hugo@example.com", "room: B-101", "changed:"} {
+		if !strings.Contains(string(data), key) {
+			t.Fatalf("user file should contain '%s':\n%s", key, data)
+		}
+	}
+
+	set := meta.Changed
+	time.Sleep(10 * time.Millisecond)
+	if err := u.Update(func(meta *UserMeta) error {
+		meta.Mail = "hugo.huber@example.com"
+		delete(meta.Extra, "room")
+		return nil
+	}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if meta, err = u.Get(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if meta.FirstName != "Hugo" || meta.Mail != "hugo.huber@example.com" {
+		t.Fatalf("meta data was not updated correctly: %+v", meta)
+	}
+	if _, exists := meta.Extra["room"]; exists {
+		t.Fatalf("extra meta data was not updated correctly: %+v", meta.Extra)
+	}
+	if !meta.Changed.After(set) {
+		t.Fatal("Update should update the changed timestamp")
+	}
+
+	if err := u.Update(func(meta *UserMeta) error {
+		meta.Mail = "nobody@example.com"
+		return os.ErrInvalid
+	}); err != os.ErrInvalid {
+		t.Fatal("Update should return the error of the update function, got:", err)
+	}
+	if meta, err = u.Get(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if meta.Mail != "hugo.huber@example.com" {
+		t.Fatal("Update must not change the user file if the update function fails")
+	}
+}